@@ -0,0 +1,44 @@
+package regox
+
+import "strconv"
+
+//ErrorKind describes what is wrong with a malformed regex
+type ErrorKind string
+
+//the kinds of syntax error that Compile can report
+const (
+	ErrMissingParen          ErrorKind = "missing closing )"
+	ErrUnexpectedParen       ErrorKind = "unexpected )"
+	ErrMissingBracket        ErrorKind = "missing closing ]"
+	ErrMissingBrace          ErrorKind = "missing closing }"
+	ErrTrailingBackslash     ErrorKind = "trailing backslash at end of expression"
+	ErrInvalidEscape         ErrorKind = "invalid escape sequence"
+	ErrMissingRepeatArgument ErrorKind = "missing argument to repetition operator"
+	ErrInvalidRepeatOp       ErrorKind = "invalid nested repetition operator"
+	ErrInvalidRepeatSize     ErrorKind = "invalid repeat count"
+	ErrInvalidCharRange      ErrorKind = "invalid character class range"
+	ErrInvalidCharClass      ErrorKind = "invalid character class"
+	ErrInvalidPerlOp         ErrorKind = "invalid or unsupported Perl syntax"
+	ErrInvalidNamedCapture   ErrorKind = "invalid named capture"
+	ErrInvalidUTF8           ErrorKind = "invalid UTF-8"
+	ErrUnboundedLookbehind   ErrorKind = "lookbehind without a bounded length"
+)
+
+func (kind ErrorKind) String() string {
+	return string(kind)
+}
+
+//SyntaxError is returned by Compile when a regex cannot be parsed
+type SyntaxError struct {
+	Kind     ErrorKind //what went wrong?
+	Offset   int       //the byte offset into the regex where the problem starts
+	Fragment string    //the offending part of the regex
+}
+
+func (err *SyntaxError) Error() string {
+	return "regox: " + string(err.Kind) + " at offset " + strconv.Itoa(err.Offset) + ": `" + err.Fragment + "`"
+}
+
+func syntaxError(kind ErrorKind, offset int, fragment string) *SyntaxError {
+	return &SyntaxError{Kind: kind, Offset: offset, Fragment: fragment}
+}
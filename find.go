@@ -0,0 +1,185 @@
+package regox
+
+import "unicode/utf8"
+
+//search finds the leftmost match in s that starts at or after pos.  A regex compiled with MatchFull can only match from the start of s
+func (regex *Regex) search(s string, pos int) RegResult {
+	for i := pos; i <= len(s); {
+		if regex.full && i > 0 {
+			break
+		}
+		res := regex.matchAt(s, i)
+		if res.Success {
+			return res
+		}
+		if i == len(s) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:len(s)])
+		i += size //only start matches on character boundaries
+	}
+	return failure()
+}
+
+//allMatches calls deliver with each successive non-overlapping match in s, stopping after n matches unless n is negative.
+//Empty matches follow RE2's rules: an empty match is found at every position, including the end of s,
+//except directly after the previous match, and the search steps forward a whole rune past each empty match
+func (regex *Regex) allMatches(s string, n int, deliver func(RegResult)) {
+	prevEnd := -1
+	for pos, count := 0, 0; pos <= len(s) && (n < 0 || count < n); {
+		res := regex.search(s, pos)
+		if !res.Success {
+			return
+		}
+		accept := true
+		if res.groups[1] == pos {
+			if res.groups[0] == prevEnd {
+				accept = false //an empty match can't abut the previous match
+			}
+			if pos < len(s) {
+				_, width := utf8.DecodeRuneInString(s[pos:])
+				pos += width
+			} else {
+				pos++
+			}
+		} else {
+			pos = res.groups[1]
+		}
+		prevEnd = res.groups[1]
+		if accept {
+			deliver(res)
+			count++
+		}
+	}
+}
+
+//FindIndex returns the start and end offsets of the leftmost match in b, or nil if there is no match
+func (regex *Regex) FindIndex(b []byte) []int {
+	return regex.FindStringIndex(string(b))
+}
+
+//FindStringIndex returns the start and end offsets of the leftmost match in s, or nil if there is no match
+func (regex *Regex) FindStringIndex(s string) []int {
+	res := regex.search(s, 0)
+	if !res.Success {
+		return nil
+	}
+	return res.groups[0:2]
+}
+
+//FindSubmatchIndex returns the start and end offsets of the leftmost match in b followed by those of each capture group, or nil if there is no match.
+//Offsets 2n and 2n+1 belong to group n, and are -1 if the group didn't take part in the match
+func (regex *Regex) FindSubmatchIndex(b []byte) []int {
+	return regex.FindStringSubmatchIndex(string(b))
+}
+
+//FindStringSubmatchIndex is like FindSubmatchIndex but searches a string
+func (regex *Regex) FindStringSubmatchIndex(s string) []int {
+	res := regex.search(s, 0)
+	if !res.Success {
+		return nil
+	}
+	return res.groups
+}
+
+//FindAllSubmatchIndex returns the offsets of the match and capture groups, as given by FindSubmatchIndex, for successive non-overlapping matches in b.
+//It returns at most n matches, or all of them if n is negative, and nil if there is no match
+func (regex *Regex) FindAllSubmatchIndex(b []byte, n int) [][]int {
+	return regex.FindAllStringSubmatchIndex(string(b), n)
+}
+
+//FindAllStringSubmatchIndex is like FindAllSubmatchIndex but searches a string
+func (regex *Regex) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	var indices [][]int
+	regex.allMatches(s, n, func(res RegResult) {
+		indices = append(indices, res.groups)
+	})
+	return indices
+}
+
+//Find returns the leftmost match in b, or nil if there is no match
+func (regex *Regex) Find(b []byte) []byte {
+	loc := regex.FindIndex(b)
+	if loc == nil {
+		return nil
+	}
+	return b[loc[0]:loc[1]:loc[1]]
+}
+
+//FindString returns the leftmost match in s, or "" if there is no match.  Use FindStringIndex to tell no match apart from an empty match
+func (regex *Regex) FindString(s string) string {
+	return regex.search(s, 0).Coverage
+}
+
+//FindSubmatch returns the leftmost match in b followed by what each capture group matched, or nil if there is no match.
+//Groups that didn't take part in the match are nil
+func (regex *Regex) FindSubmatch(b []byte) [][]byte {
+	loc := regex.FindSubmatchIndex(b)
+	if loc == nil {
+		return nil
+	}
+	return submatches(b, loc)
+}
+
+//FindStringSubmatch returns the leftmost match in s followed by what each capture group matched, or nil if there is no match.
+//Groups that didn't take part in the match are ""
+func (regex *Regex) FindStringSubmatch(s string) []string {
+	res := regex.search(s, 0)
+	if !res.Success {
+		return nil
+	}
+	return res.Captures
+}
+
+//FindAll returns successive non-overlapping matches in b, stopping after n matches unless n is negative.  It returns nil if there is no match
+func (regex *Regex) FindAll(b []byte, n int) [][]byte {
+	var matches [][]byte
+	for _, loc := range regex.FindAllIndex(b, n) {
+		matches = append(matches, b[loc[0]:loc[1]:loc[1]])
+	}
+	return matches
+}
+
+//FindAllString returns successive non-overlapping matches in s, stopping after n matches unless n is negative.  It returns nil if there is no match
+func (regex *Regex) FindAllString(s string, n int) []string {
+	var matches []string
+	regex.allMatches(s, n, func(res RegResult) {
+		matches = append(matches, res.Coverage)
+	})
+	return matches
+}
+
+//FindAllIndex returns the start and end offsets of successive non-overlapping matches in b, stopping after n matches unless n is negative
+func (regex *Regex) FindAllIndex(b []byte, n int) [][]int {
+	return regex.FindAllStringIndex(string(b), n)
+}
+
+//FindAllStringIndex is like FindAllIndex but searches a string
+func (regex *Regex) FindAllStringIndex(s string, n int) [][]int {
+	var indices [][]int
+	regex.allMatches(s, n, func(res RegResult) {
+		indices = append(indices, res.groups[0:2])
+	})
+	return indices
+}
+
+//FindAllStringSubmatch returns the match and capture groups, as given by FindStringSubmatch, of successive non-overlapping matches in s.
+//It stops after n matches unless n is negative, and returns nil if there is no match
+func (regex *Regex) FindAllStringSubmatch(s string, n int) [][]string {
+	var matches [][]string
+	regex.allMatches(s, n, func(res RegResult) {
+		matches = append(matches, res.Captures)
+	})
+	return matches
+}
+
+//submatches slices out of b the match and capture groups located by loc
+func submatches(b []byte, loc []int) [][]byte {
+	groups := make([][]byte, len(loc)/2)
+	for i := range groups {
+		if loc[2*i] >= 0 {
+			groups[i] = b[loc[2*i]:loc[2*i+1]:loc[2*i+1]]
+		}
+	}
+	return groups
+}
@@ -2,23 +2,124 @@ package regox
 
 import (
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-//Parse takes a string regex and parses it into a regex object
+//token is a single lexical element of a regex along with the byte offset it starts at and the flags in effect for it
+type token struct {
+	text  string
+	pos   int
+	flags flags
+	group int //the number of the capture group this token opens, if it opens one
+}
+
+//flags are the inline options set by groups like (?m), which apply until the end of the enclosing group
+type flags uint8
+
+const (
+	flagMultiline flags = 1 << iota //^ and $ match at line boundaries
+	flagUnicode                     //\d, \s, \w and \b use the Unicode definitions of digits, spaces and word characters
+	flagFoldCase                    //letters match regardless of case
+	flagDotNL                       //. matches \n as well
+	flagUngreedy                    //quantifiers are lazy unless followed by ?, which makes them greedy
+	flagExtended                    //whitespace and # comments outside of sets are ignored
+)
+
+//flagLetters maps the letter for each flag in (?flags) to the flag
+var flagLetters = map[byte]flags{
+	'i': flagFoldCase,
+	'm': flagMultiline,
+	's': flagDotNL,
+	'U': flagUngreedy,
+	'x': flagExtended,
+}
+
+//Option changes how Compile treats a regex
+type Option uint
+
+const (
+	MatchFull       Option = 1 << iota //matches must cover the whole input, as if the regex were wrapped in \A(?:...)\z
+	UnicodeClasses                     //\d, \s, \w and \b use the Unicode definitions of digits, spaces and word characters rather than the ASCII ones
+	CaseInsensitive                    //letters match regardless of case, as if the regex started with (?i)
+)
+
+//Compile parses a regex into a Regex object, returning a *SyntaxError if the regex is malformed
+func Compile(regex string, options ...Option) (*Regex, error) {
+	opts := Option(0)
+	for _, option := range options {
+		opts |= option
+	}
+	initial := flags(0)
+	if opts&UnicodeClasses != 0 {
+		initial |= flagUnicode
+	}
+	if opts&CaseInsensitive != 0 {
+		initial |= flagFoldCase
+	}
+	tokens, err := tokenize(regex, initial)
+	if err != nil {
+		return nil, err
+	}
+	return &Regex{expression: regex, exprTree: tparse(tokens), subexpNames: subexpNames(tokens), full: opts&MatchFull != 0}, nil
+}
+
+//MustCompile is like Compile but panics if the regex is malformed
+func MustCompile(regex string, options ...Option) *Regex {
+	rgx, err := Compile(regex, options...)
+	if err != nil {
+		panic(err)
+	}
+	return rgx
+}
+
+//Parse takes a string regex and parses it into a regex object.  It panics if the regex is malformed, use Compile to handle the error instead
 func Parse(regex string) Regex {
-	return Regex{expression: regex, exprTree: cparse(regex)}
+	return *MustCompile(regex)
 }
 
-func cparse(regex string) consumer {
-	return tparse(tokenize(regex))
+//metaChars are the characters that tokenize treats specially outside of a set, including the whitespace and # that (?x) skips
+const metaChars = "\\.+*?()|[]{}^$# \t\n\r\f\v"
+
+//QuoteMeta escapes every metacharacter in s, returning a regex that matches s literally.  The result also matches s literally in (?x) mode
+func QuoteMeta(s string) string {
+	quoted := ""
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(metaChars, s[i]) != -1 { //every metacharacter is ASCII, so the bytes of other characters can be copied one by one
+			quoted += "\\"
+		}
+		quoted += s[i : i+1]
+	}
+	return quoted
+}
+
+//subexpNames lists the name of each capture group opened in the tokens, in the order they are numbered
+func subexpNames(tokens []token) []string {
+	names := []string{""}
+	for _, tok := range tokens {
+		if tok.group > 0 {
+			names = append(names, groupName(tok.text))
+		}
+	}
+	return names
+}
+
+func tparse(regex []token) consumer {
+	return splitAlternation(regex)
 }
 
-func tparse(regex []string) consumer {
-	return splitConcatenation(regex)
+//SplitAlternation takes tokens that may contain pipes at the top level and unions the alternatives
+func splitAlternation(regex []token) consumer {
+	alternatives := splitUnion(regex)
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+	return union(alternatives...)
 }
 
 //SplitConcatenation takes a regex and separates it then sorts it into a concatenation
-func splitConcatenation(regex []string) consumer {
+func splitConcatenation(regex []token) consumer {
 	if len(regex) == 0 {
 		return atom("")
 	}
@@ -36,132 +137,258 @@ func splitConcatenation(regex []string) consumer {
 }
 
 //SplitRegex splits a regex into a body and a tail, the tail being the trailing expression
-func splitRegex(regex []string) ([]string, consumer) {
+func splitRegex(regex []token) ([]token, consumer) {
 	lastToken := regex[len(regex)-1]
-	if len(regex) == 1 {
-		return make([]string, 0), splitSingular(regex[0])
-	}
-	if lastToken == ")" {
+	if lastToken.text == ")" {
 		body, tail := separens(regex, "(", ")")
-		if parencontains(tail, "|") {
-			return body, union(splitUnion(tail)...)
-		}
-		return body, capture(splitConcatenation(tail[1 : len(tail)-1]))
-	}
-	if lastToken == "}" {
-		body, tail := separens(regex, "{", "}")
-		nbody, repeater := splitRegex(body)
-		if strcontains(tail[1], ',') {
-			lower, upper := strSplit(tail[1], ',')
-			l, _ := strconv.Atoi(lower)
-			var u int
-			if upper == "" {
-				u = -1
-			} else {
-				u, _ = strconv.Atoi(upper)
-			}
-			return nbody, rangeRepeat(repeater, l, u)
+		inner := splitAlternation(tail[1 : len(tail)-1])
+		if tail[0].text == "(?>" {
+			return body, atomic(inner)
+		}
+		if tail[0].text == "(?=" {
+			return body, lookahead(inner)
+		}
+		if tail[0].text == "(?!" {
+			return body, negativeLookahead(inner)
+		}
+		if tail[0].text == "(?<=" {
+			minWidth, maxWidth := widthRange(tail[1 : len(tail)-1])
+			return body, lookbehind(inner, minWidth, maxWidth)
+		}
+		if tail[0].text == "(?<!" {
+			minWidth, maxWidth := widthRange(tail[1 : len(tail)-1])
+			return body, negativeLookbehind(inner, minWidth, maxWidth)
+		}
+		if tail[0].group > 0 {
+			return body, capture(tail[0].group, inner)
 		}
-		repetitions, _ := strconv.Atoi(tail[1])
-		return nbody, repeat(repeater, repetitions)
+		return body, inner
 	}
-	if lastToken == "]" {
-		body, tail := separens(regex, "[", "]")
-		setContents := abbreviate(tail)
-		setTokens := setTokenize(setContents[1 : len(setContents)-1])
-		if setTokens[0] == "^" {
-			return body, negate(set(splitSet(setTokens)))
+	if isQuantifier(lastToken.text) {
+		body, repeater := splitRegex(regex[0 : len(regex)-1])
+		if lastToken.flags&flagUngreedy != 0 {
+			return body, quantify(repeater, swapGreed(lastToken.text))
 		}
-		return body, set(splitSet(setTokens))
+		return body, quantify(repeater, lastToken.text)
+	}
+	return regex[0 : len(regex)-1], splitSingular(lastToken.text, lastToken.flags)
+}
+
+//Quantify wraps a consumer in the repetition described by a quantifier token.  A trailing ? makes the quantifier lazy, a trailing + makes it possessive
+func quantify(cons consumer, quantifier string) consumer {
+	if isPossessive(quantifier) {
+		return atomic(quantify(cons, quantifier[0:len(quantifier)-1]))
+	}
+	if isLazy(quantifier) {
+		lower, upper := quantifierBounds(quantifier[0 : len(quantifier)-1])
+		return lazyRepeat(cons, lower, upper)
 	}
-	if lastToken == "*" {
-		body, tail := splitRegex(regex[0 : len(regex)-1])
-		return body, star(tail)
+	if quantifier == "*" {
+		return star(cons)
 	}
-	if lastToken == "+" {
-		body, tail := splitRegex(regex[0 : len(regex)-1])
-		return body, plus(tail)
+	if quantifier == "+" {
+		return plus(cons)
+	}
+	if quantifier == "?" {
+		return option(cons)
+	}
+	lower, upper := quantifierBounds(quantifier)
+	if lower == upper {
+		return repeat(cons, lower)
+	}
+	return rangeRepeat(cons, lower, upper)
+}
+
+//swapGreed turns a greedy quantifier into a lazy one and a lazy quantifier into a greedy one, for (?U).  Possessive quantifiers are left as they are
+func swapGreed(quantifier string) string {
+	if isPossessive(quantifier) {
+		return quantifier
 	}
-	if lastToken == "?" {
-		body, tail := splitRegex(regex[0 : len(regex)-1])
-		return body, option(tail)
+	if isLazy(quantifier) {
+		return quantifier[0 : len(quantifier)-1]
 	}
-	return regex[0 : len(regex)-1], splitSingular(regex[len(regex)-1])
+	return quantifier + "?"
 }
 
 //SplitSingular takes an atomic regular expression and parses it
-func splitSingular(regex string) consumer {
+func splitSingular(regex string, flags flags) consumer {
 	if regex == "." {
-		return any()
+		if flags&flagDotNL != 0 {
+			return any()
+		}
+		return notNewline()
+	}
+
+	if regex == "^" {
+		if flags&flagMultiline != 0 {
+			return lineStart()
+		}
+		return textStart()
+	}
+
+	if regex == "$" {
+		if flags&flagMultiline != 0 {
+			return lineEnd()
+		}
+		return textEnd()
+	}
+
+	if regex[0] == '[' {
+		setTokens, _ := setTokenize(regex[1 : len(regex)-1]) //already validated by tokenize
+		if setTokens[0] == "^" {
+			return negate(set(splitSet(setTokens[1:len(setTokens)], flags)))
+		}
+		return set(splitSet(setTokens, flags))
 	}
 
 	if regex[0] == '\\' {
 		escChar := regex[1]
 		if escChar == 'd' {
-			return digit()
+			return perlClass(escChar, flags)
 		}
 		if escChar == '\\' {
 			return backslash()
 		}
 		if escChar == 's' {
-			return space()
+			return perlClass(escChar, flags)
 		}
 		if escChar == 't' {
 			return tab()
 		}
 		if escChar == 'D' {
-			return negate(digit())
+			return negate(perlClass('d', flags))
 		}
 		if escChar == 'T' {
 			return negate(tab())
 		}
 		if escChar == 'S' {
-			return negate(space())
+			return negate(perlClass('s', flags))
 		}
 		if escChar == 'w' {
-			return word()
+			return perlClass(escChar, flags)
 		}
 		if escChar == 'W' {
-			return negate(word())
+			return negate(perlClass('w', flags))
+		}
+		if escChar == 'A' {
+			return textStart()
+		}
+		if escChar == 'z' {
+			return textEnd()
+		}
+		if escChar == 'Z' {
+			return finalEnd()
+		}
+		if escChar == 'b' {
+			return wordBoundary(wordTest(flags))
+		}
+		if escChar == 'B' {
+			return nonWordBoundary(wordTest(flags))
+		}
+		if escChar == 'p' || escChar == 'P' {
+			name, negated := unicodeClassName(regex)
+			if negated {
+				return negate(unicodeClass(unicodeTable(name), flags&flagFoldCase != 0))
+			}
+			return unicodeClass(unicodeTable(name), flags&flagFoldCase != 0)
 		}
-		return atom(string(escChar))
+		char, _ := escapeChar(regex)
+		return literal(string(char), flags)
+	}
+	return literal(regex, flags)
+}
+
+//literal matches a run of explicit characters, ignoring case if flagFoldCase is set
+func literal(text string, flags flags) consumer {
+	if flags&flagFoldCase != 0 {
+		return foldedAtom(text)
+	}
+	if strings.ContainsRune(text, utf8.RuneError) {
+		//U+FFFD stands for invalid UTF-8 in the input too, so it has to be compared as a decoded character
+		return decodedAtom(text, func(want, char rune) bool {
+			return want == char
+		})
 	}
-	return atom(regex)
+	return atom(text)
+}
+
+//charRange matches a character in between lower and upper, ignoring case if flagFoldCase is set
+func charRange(lower, upper rune, flags flags) consumer {
+	if flags&flagFoldCase != 0 {
+		return foldedRange(lower, upper)
+	}
+	return inRange(lower, upper)
 }
 
 //SplitSet takes tokens (from a set tokenization) within a set and builds a consumer slice to generate a set Atom
-func splitSet(regex []string) []consumer {
+func splitSet(regex []string, flags flags) []consumer {
 	cons := make([]consumer, 0)
 	for _, token := range regex {
 		var con consumer
-		if token == "." {
-			con = atom(".")
-		} else if len(token) < 3 {
-			con = splitSingular(token)
+		chars := []rune(token)
+		if strings.HasPrefix(token, "[:") {
+			name, negated := posixClassName(token)
+			con = posixClass(posixClasses[name], flags&flagFoldCase != 0)
+			if negated {
+				con = negate(con)
+			}
+		} else if len(chars) == 3 && chars[1] == '-' {
+			con = charRange(chars[0], chars[2], flags)
+		} else if char, ok := setChar(token); ok {
+			con = charRange(char, char, flags) //compares the decoded character, so U+FFFD matches invalid UTF-8 just as . sees it
 		} else {
-			con = inRange(token[0], token[2])
+			con = splitSingular(token, flags)
 		}
 		cons = append(cons, con)
 	}
 	return cons
 }
 
-//SplitUnion takes a token section and splits it into an array of subexpressions, split by the pipe character
-func splitUnion(regex []string) []consumer {
-	tokBuffer := make([]string, 0)
+//perlClass builds \d, \s or \w, which only match ASCII characters unless flagUnicode is set
+func perlClass(escChar byte, flags flags) consumer {
+	unicodeMode := flags&flagUnicode != 0
+	if escChar == 'd' {
+		if unicodeMode {
+			return unicodeDigit()
+		}
+		return digit()
+	}
+	if escChar == 's' {
+		if unicodeMode {
+			return unicodeSpace()
+		}
+		return space()
+	}
+	if unicodeMode {
+		return unicodeWord()
+	}
+	return word()
+}
+
+//wordTest picks the definition of a word character that \w and \b use
+func wordTest(flags flags) func(rune) bool {
+	if flags&flagUnicode != 0 {
+		return isUnicodeWordChar
+	}
+	return isWordChar
+}
+
+//SplitUnion takes a token section and splits it into an array of subexpressions, split by top level pipe characters
+func splitUnion(regex []token) []consumer {
+	tokBuffer := make([]token, 0)
 	consumers := make([]consumer, 0)
 	level := 0
-	for _, token := range regex[1 : len(regex)-1] {
-		if token == "(" {
+	for _, tok := range regex {
+		if isOpener(tok.text) {
 			level++
-		} else if token == ")" {
+		} else if tok.text == ")" {
 			level--
 		}
-		if level == 0 && token == "|" {
+		if level == 0 && tok.text == "|" {
 			consumers = append(consumers, splitConcatenation(tokBuffer))
-			tokBuffer = make([]string, 0)
+			tokBuffer = make([]token, 0)
 		} else {
-			tokBuffer = append(tokBuffer, token)
+			tokBuffer = append(tokBuffer, tok)
 		}
 	}
 	consumers = append(consumers, splitConcatenation(tokBuffer))
@@ -171,83 +398,738 @@ func splitUnion(regex []string) []consumer {
 //	a-z-A-Z\\\\asA-zdf\\d.\\.-
 //	a-z - A-Z \\\\ a s A-z d f \\d . \\. -
 
-//setTokenize takes the contents of a set and tokenize it into elements
-func setTokenize(s string) []string {
-	buffer := ""
+//setTokenize takes the contents of a set and tokenize it into elements.  Offsets in a returned *SyntaxError are relative to s.
+//Ranges are given with their ends decoded, so [\x41-\x5a] gives A-Z, while other elements keep the text they were written with
+func setTokenize(s string) ([]string, error) {
 	tokens := make([]string, 0)
+	offset := 0
 	if s[0] == '^' {
 		tokens = append(tokens, "^")
 		s = s[1:len(s)]
+		offset = 1
 	}
-	for _, char := range s {
-		if len(buffer) == 0 {
-			buffer += string(char)
-		} else if len(buffer) == 1 {
-			first := buffer[0]
-			if first == '\\' {
-				tokens = append(tokens, buffer+string(char))
-				buffer = ""
-			} else if char != '-' {
-				tokens = append(tokens, buffer)
-				buffer = string(char)
-			} else {
-				buffer += string(char)
+	for i := 0; i < len(s); {
+		element, err := setElement(s, i)
+		if err != nil {
+			err.Offset += offset
+			return nil, err
+		}
+		end := i + len(element)
+		if lower, ok := setChar(element); ok && end+1 < len(s) && s[end] == '-' {
+			upperElement, err := setElement(s, end+1)
+			if err != nil {
+				err.Offset += offset
+				return nil, err
 			}
-		} else if len(buffer) == 2 {
-			tokens = append(tokens, buffer+string(char))
-			buffer = ""
+			rangeEnd := end + 1 + len(upperElement)
+			upper, ok := setChar(upperElement)
+			if !ok || lower > upper {
+				return nil, syntaxError(ErrInvalidCharRange, offset+i, s[i:rangeEnd])
+			}
+			tokens = append(tokens, string(lower)+"-"+string(upper))
+			i = rangeEnd
+			continue
 		}
+		tokens = append(tokens, element)
+		i = end
 	}
-	if len(buffer) > 0 {
-		tokens = append(tokens, buffer)
+	return tokens, nil
+}
+
+//setElement reads the element of a set starting at index start: a class such as [:alpha:] or \d, an escaped character, or a character
+func setElement(s string, start int) (string, *SyntaxError) {
+	if s[start] == '\\' {
+		escape, err := escapeText(s, start)
+		if err != nil {
+			return "", err
+		}
+		if len(escape) == 2 && strcontains("AzZB", rune(escape[1])) {
+			//an assertion matches a position, not a character.  \b is a backspace in a set
+			return "", syntaxError(ErrInvalidEscape, start, escape)
+		}
+		return escape, nil
 	}
-	return tokens
+	if end := posixClassEnd(s, start); end != -1 {
+		class := s[start:end]
+		if name, _ := posixClassName(class); posixClasses[name] == "" {
+			return "", syntaxError(ErrInvalidCharRange, start, class)
+		}
+		return class, nil
+	}
+	char, size := utf8.DecodeRuneInString(s[start:len(s)])
+	if char == utf8.RuneError && size == 1 {
+		return "", syntaxError(ErrInvalidUTF8, start, s[start:start+1])
+	}
+	return s[start : start+size], nil
+}
+
+//setChar returns the character an element of a set stands for, or false if it is a class.  Inside a set \b is a backspace
+func setChar(element string) (rune, bool) {
+	if element == "\\b" {
+		return '\b', true
+	}
+	if element[0] == '\\' {
+		return escapeChar(element)
+	}
+	if strings.HasPrefix(element, "[:") {
+		return 0, false
+	}
+	char, _ := utf8.DecodeRuneInString(element)
+	return char, true
+}
+
+//controlEscapes maps the letter of each escape for a control character to the character
+var controlEscapes = map[byte]rune{
+	'a': '\a',
+	'f': '\f',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'v': '\v',
+}
+
+//escapeText reads the escape sequence starting at the backslash at index start, checking that it is well formed.
+//Besides a backslash and a supported letter or a punctuation character it can be a Unicode class like \p{Greek}, a code point written \xHH, \x{H...} or \uHHHH,
+//or an octal character code of up to three digits, which must start with 0 if it is a single digit, as \1 to \7 would be a backreference
+func escapeText(regex string, start int) (string, *SyntaxError) {
+	if start+1 >= len(regex) {
+		return "", syntaxError(ErrTrailingBackslash, start, regex[start:len(regex)])
+	}
+	esc, size := utf8.DecodeRuneInString(regex[start+1 : len(regex)])
+	end := start + 1 + size
+	if esc == 'p' || esc == 'P' {
+		return unicodeClassText(regex, start)
+	}
+	if esc == 'x' && end < len(regex) && regex[end] == '{' {
+		close := strings.IndexByte(regex[end:len(regex)], '}')
+		if close == -1 {
+			return "", syntaxError(ErrInvalidEscape, start, regex[start:len(regex)])
+		}
+		end += close + 1
+		if !isCodePoint(regex[start+3 : end-1]) {
+			return "", syntaxError(ErrInvalidEscape, start, regex[start:end])
+		}
+	} else if esc == 'x' || esc == 'u' {
+		digits := 2
+		if esc == 'u' {
+			digits = 4
+		}
+		if end+digits > len(regex) || !isCodePoint(regex[end:end+digits]) {
+			return "", syntaxError(ErrInvalidEscape, start, regex[start:end])
+		}
+		end += digits
+	} else if esc >= '1' && esc <= '7' && (end == len(regex) || !isOctal(regex[end])) {
+		return "", syntaxError(ErrInvalidEscape, start, regex[start:end])
+	} else if esc >= '0' && esc <= '7' {
+		for digits := 1; digits < 3 && end < len(regex) && isOctal(regex[end]); digits++ {
+			end++
+		}
+	} else if esc >= utf8.RuneSelf || (isWordChar(esc) && esc != '_' && !strcontains("dDsSwWtTAzZbBafnrv", esc)) {
+		//only ASCII punctuation can be escaped to stand for itself, leaving the other letters and digits free for new escapes
+		return "", syntaxError(ErrInvalidEscape, start, regex[start:end])
+	}
+	return regex[start:end], nil
+}
+
+//escapeChar returns the character an escape sequence such as \n, \x41 or \. stands for, or false if it stands for a class or an assertion like \d or \b
+func escapeChar(escape string) (rune, bool) {
+	esc, _ := utf8.DecodeRuneInString(escape[1:len(escape)])
+	if strcontains("dDsSwWTAzZbBpP", esc) {
+		return 0, false
+	}
+	if char, ok := controlEscapes[escape[1]]; ok {
+		return char, true
+	}
+	if esc == 'x' || esc == 'u' {
+		value, _ := strconv.ParseUint(strings.Trim(escape[2:len(escape)], "{}"), 16, 32)
+		return rune(value), true
+	}
+	if esc >= '0' && esc <= '7' {
+		value, _ := strconv.ParseUint(escape[1:len(escape)], 8, 32)
+		return rune(value), true
+	}
+	return esc, true
+}
+
+//isCodePoint reports whether hex is a hexadecimal number naming a Unicode character other than a surrogate
+func isCodePoint(hex string) bool {
+	value, err := strconv.ParseUint(hex, 16, 32)
+	return err == nil && value <= unicode.MaxRune && (value < 0xD800 || value > 0xDFFF)
+}
+
+func isOctal(char byte) bool {
+	return char >= '0' && char <= '7'
+}
+
+//posixClasses holds the characters in each POSIX class such as [:alpha:], written as pairs of the lowest and highest character in each range
+var posixClasses = map[string]string{
+	"alnum":  "09AZaz",
+	"alpha":  "AZaz",
+	"ascii":  "\x00\x7f",
+	"blank":  "\t\t  ",
+	"cntrl":  "\x00\x1f\x7f\x7f",
+	"digit":  "09",
+	"graph":  "!~",
+	"lower":  "az",
+	"print":  " ~",
+	"punct":  "!/:@[`{~",
+	"space":  "\t\r  ",
+	"upper":  "AZ",
+	"word":   "09AZaz__",
+	"xdigit": "09AFaf",
+}
+
+//posixClassEnd returns the index just past a POSIX class such as [:alpha:] starting at index start, or -1 if there isn't one there
+func posixClassEnd(regex string, start int) int {
+	if !strings.HasPrefix(regex[start:len(regex)], "[:") {
+		return -1
+	}
+	end := strings.Index(regex[start+2:len(regex)], ":]")
+	if end == -1 {
+		return -1
+	}
+	return start + 2 + end + 2
+}
+
+//posixClassName takes a class written as [:alpha:] or [:^alpha:] and returns its name and whether it is negated
+func posixClassName(class string) (string, bool) {
+	name := class[2 : len(class)-2]
+	if strings.HasPrefix(name, "^") {
+		return name[1:len(name)], true
+	}
+	return name, false
+}
+
+//unicodeClassText reads a Unicode class such as \pL, \p{Greek} or \P{^Lu} starting at the backslash at index start, checking that the class exists
+func unicodeClassText(regex string, start int) (string, *SyntaxError) {
+	end := start + 2
+	if end < len(regex) && regex[end] == '{' {
+		close := strings.IndexByte(regex[end:len(regex)], '}')
+		if close == -1 {
+			return "", syntaxError(ErrInvalidCharClass, start, regex[start:len(regex)])
+		}
+		end += close + 1
+	} else if end < len(regex) {
+		_, size := utf8.DecodeRuneInString(regex[end:len(regex)])
+		end += size
+	}
+	text := regex[start:end]
+	if name, _ := unicodeClassName(text); unicodeTable(name) == nil {
+		return "", syntaxError(ErrInvalidCharClass, start, text)
+	}
+	return text, nil
+}
+
+//unicodeClassName takes a class written as \pL, \p{Greek} or \P{^Lu} and returns the name of its table and whether it is negated
+func unicodeClassName(class string) (string, bool) {
+	negated := class[1] == 'P'
+	name := class[2:len(class)]
+	if strings.HasPrefix(name, "{") {
+		name = name[1 : len(name)-1]
+	}
+	if strings.HasPrefix(name, "^") {
+		negated = !negated
+		name = name[1:len(name)]
+	}
+	return name, negated
+}
+
+//anyTable holds every code point, for \p{Any}
+var anyTable = &unicode.RangeTable{
+	R16: []unicode.Range16{{Lo: 0, Hi: 0xFFFF, Stride: 1}},
+	R32: []unicode.Range32{{Lo: 0x10000, Hi: unicode.MaxRune, Stride: 1}},
+}
+
+//unicodeTable looks up a Unicode general category such as L or Lu, or a script such as Greek, returning nil if there is no such class
+func unicodeTable(name string) *unicode.RangeTable {
+	if name == "Any" {
+		return anyTable
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return table
+	}
+	if table, ok := unicode.Scripts[name]; ok {
+		return table
+	}
+	return nil
 }
 
 //	aa\\\\bcd\\dasf(abc){2}de
-//	aa \\ \\ bcd \\d asdf ( abc ) { 2 } de
+//	aa \\ \\ bcd \\d asdf ( abc ) {2} de
 
-//tokenize takes a regex and splits it into tokens
-func tokenize(regex string) []string {
+//tokenize takes a regex and splits it into tokens, checking that it is well formed along the way
+func tokenize(regex string, initial flags) ([]token, error) {
 	buffer := ""
-	isEscaping := false
-	tokens := make([]string, 0)
-	for _, c := range regex {
-		if isEscaping {
-			tokens = append(tokens, "\\"+string(c))
-			isEscaping = false
+	bufferPos := 0
+	tokens := make([]token, 0)
+	opened := make([]int, 0)   //offsets of the parentheses that haven't been closed yet
+	scopes := make([]flags, 0) //the flags to restore when each of those parentheses closes
+	current := initial
+	flagsEnd := -1 //where the last (?flags) group ended, as nothing can be repeated straight after one
+	groups := 0
+	names := make(map[string]bool)
+	emit := func(text string, pos int) {
+		tokens = append(tokens, token{text: text, pos: pos, flags: current})
+	}
+	flush := func() {
+		if len(buffer) > 0 {
+			emit(buffer, bufferPos)
 			buffer = ""
-		} else {
-			if c == '\\' {
-				if len(buffer) > 0 {
-					tokens = append(tokens, buffer)
-					buffer = ""
+		}
+	}
+	//appendLiteral adds a literal character at offset pos to the buffer
+	appendLiteral := func(c rune, pos int) {
+		if len(buffer) > 0 && bufferPos+len(buffer) != pos {
+			flush() //the buffer only holds characters that are next to each other in the regex
+		}
+		if len(buffer) == 0 {
+			bufferPos = pos
+		}
+		buffer += string(c)
+	}
+	//appendQuantifier appends a quantifier token, making sure it has a single expression to repeat
+	appendQuantifier := func(quantifier string, pos int) error {
+		if len(buffer) > 0 {
+			//a quantifier only repeats the last character of a literal run
+			_, size := utf8.DecodeLastRuneInString(buffer)
+			head := buffer[0 : len(buffer)-size]
+			if len(head) > 0 {
+				emit(head, bufferPos)
+			}
+			emit(buffer[len(head):len(buffer)], bufferPos+len(head))
+			buffer = ""
+		}
+		if len(tokens) == 0 || pos == flagsEnd || isOpener(tokens[len(tokens)-1].text) || tokens[len(tokens)-1].text == "|" {
+			return syntaxError(ErrMissingRepeatArgument, pos, quantifier)
+		}
+		if last := tokens[len(tokens)-1]; isQuantifier(last.text) {
+			if (quantifier == "?" || quantifier == "+") && !isLazy(last.text) && !isPossessive(last.text) {
+				tokens[len(tokens)-1].text += quantifier
+				return nil
+			}
+			return syntaxError(ErrInvalidRepeatOp, last.pos, last.text+quantifier)
+		}
+		emit(quantifier, pos)
+		return nil
+	}
+	for i := 0; i < len(regex); {
+		c, size := utf8.DecodeRuneInString(regex[i:len(regex)])
+		if c == utf8.RuneError && size == 1 {
+			return nil, syntaxError(ErrInvalidUTF8, i, regex[i:i+1])
+		}
+		if current&flagExtended != 0 && (strcontains(" \t\n\r\f\v", c) || c == '#') {
+			//skipped without flushing the buffer, so a quantifier after the gap still only repeats the character before it
+			if c == '#' {
+				//a comment runs to the end of the line
+				if end := strings.IndexByte(regex[i:len(regex)], '\n'); end != -1 {
+					size = end + 1
+				} else {
+					size = len(regex) - i
+				}
+			}
+			if i == flagsEnd {
+				flagsEnd = i + size //the gap doesn't give a quantifier after it anything to repeat
+			}
+		} else if strings.HasPrefix(regex[i:len(regex)], "\\Q") {
+			//everything up to \E, or the end of the regex, is literal
+			quoted := regex[i+2 : len(regex)]
+			size = len(regex) - i
+			if end := strings.Index(quoted, "\\E"); end != -1 {
+				quoted = quoted[0:end]
+				size = end + 4
+			}
+			for j := 0; j < len(quoted); {
+				qc, qsize := utf8.DecodeRuneInString(quoted[j:len(quoted)])
+				if qc == utf8.RuneError && qsize == 1 {
+					return nil, syntaxError(ErrInvalidUTF8, i+2+j, quoted[j:j+1])
 				}
-				isEscaping = true
-			} else if strcontains("()[]{}|.+*?", c) {
-				if len(buffer) > 0 {
-					tokens = append(tokens, buffer)
-					buffer = ""
+				if strcontains(metaChars, qc) {
+					//written as an escape, just as QuoteMeta would
+					flush()
+					emit("\\"+string(qc), i+2+j)
+				} else {
+					appendLiteral(qc, i+2+j)
+				}
+				j += qsize
+			}
+		} else if c == '\\' {
+			escape, err := escapeText(regex, i)
+			if err != nil {
+				return nil, err
+			}
+			size = len(escape)
+			flush()
+			emit(regex[i:i+size], i)
+		} else if c == '[' {
+			end := setEnd(regex, i)
+			if end == -1 {
+				return nil, syntaxError(ErrMissingBracket, i, regex[i:len(regex)])
+			}
+			if _, err := setTokenize(regex[i+1 : end]); err != nil {
+				serr := err.(*SyntaxError)
+				serr.Offset += i + 1
+				return nil, serr
+			}
+			size = end + 1 - i
+			flush()
+			emit(regex[i:end+1], i)
+		} else if c == '{' {
+			end := strings.IndexByte(regex[i:len(regex)], '}')
+			if end == -1 {
+				return nil, syntaxError(ErrMissingBrace, i, regex[i:len(regex)])
+			}
+			size = end + 1
+			if _, _, ok := repeatBounds(regex[i : i+size]); !ok {
+				return nil, syntaxError(ErrInvalidRepeatSize, i, regex[i:i+size])
+			}
+			if err := appendQuantifier(regex[i:i+size], i); err != nil {
+				return nil, err
+			}
+		} else if strcontains("*+?", c) {
+			if err := appendQuantifier(string(c), i); err != nil {
+				return nil, err
+			}
+		} else if c == '(' {
+			flush()
+			if set, length := inlineFlags(regex, i, current); length > 0 {
+				size = length
+				if regex[i+size-1] == ':' {
+					//(?flags:...) is a non-capturing group with its own flags
+					opened = append(opened, i)
+					scopes = append(scopes, current)
+					emit(regex[i:i+size], i)
+				} else {
+					flagsEnd = i + size
 				}
-				tokens = append(tokens, string(c))
+				current = set
 			} else {
-				buffer += string(c)
+				opener, err := groupOpener(regex, i)
+				if err != nil {
+					return nil, err
+				}
+				size = len(opener)
+				opened = append(opened, i)
+				scopes = append(scopes, current)
+				emit(opener, i)
+				if opener == "(" || isNamed(opener) {
+					groups++
+					tokens[len(tokens)-1].group = groups
+				}
+				if name := groupName(opener); name != "" {
+					if names[name] {
+						return nil, syntaxError(ErrInvalidNamedCapture, i, opener)
+					}
+					names[name] = true
+				}
 			}
+		} else if strcontains(")|.^$", c) {
+			flush()
+			if c == ')' {
+				if len(opened) == 0 {
+					return nil, syntaxError(ErrUnexpectedParen, i, regex[0:i+1])
+				}
+				opened = opened[0 : len(opened)-1]
+				current = scopes[len(scopes)-1]
+				scopes = scopes[0 : len(scopes)-1]
+			}
+			emit(string(c), i)
+		} else {
+			appendLiteral(c, i)
 		}
+		i += size
 	}
-	if len(buffer) > 0 {
-		tokens = append(tokens, buffer)
-		buffer = ""
+	if len(opened) > 0 {
+		open := opened[len(opened)-1]
+		return nil, syntaxError(ErrMissingParen, open, regex[open:len(regex)])
 	}
-	return tokens
+	flush()
+	for i, tok := range tokens {
+		//a lookbehind tries every starting point it could have, so it can only look a bounded distance back
+		if tok.text == "(?<=" || tok.text == "(?<!" {
+			close := closingParen(tokens, i)
+			if _, maxWidth := widthRange(tokens[i+1 : close]); maxWidth == -1 {
+				return nil, syntaxError(ErrUnboundedLookbehind, tok.pos, regex[tok.pos:tokens[close].pos+1])
+			}
+		}
+	}
+	return tokens, nil
+}
+
+//closingParen returns the index of the token closing the group opened by the token at index open
+func closingParen(tokens []token, open int) int {
+	level := 0
+	for i := open; i < len(tokens); i++ {
+		if isOpener(tokens[i].text) {
+			level++
+		} else if tokens[i].text == ")" {
+			level--
+			if level == 0 {
+				return i
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+//widthRange returns the fewest and the most bytes that the tokens can match, where a most of -1 means there is no limit
+func widthRange(tokens []token) (int, int) {
+	minWidth, maxWidth := 0, 0
+	level := 0
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) {
+			if isOpener(tokens[i].text) {
+				level++
+			} else if tokens[i].text == ")" {
+				level--
+			}
+			if level > 0 || tokens[i].text != "|" {
+				continue
+			}
+		}
+		//each alternative separated by a top level | is measured on its own
+		low, high := sequenceWidth(tokens[start:i])
+		if start == 0 || low < minWidth {
+			minWidth = low
+		}
+		if maxWidth != -1 && (high == -1 || high > maxWidth) {
+			maxWidth = high
+		}
+		start = i + 1
+	}
+	return minWidth, maxWidth
+}
+
+//sequenceWidth is widthRange for tokens without a top level |
+func sequenceWidth(tokens []token) (int, int) {
+	minWidth, maxWidth := 0, 0
+	for i := 0; i < len(tokens); {
+		var low, high int
+		next := i + 1
+		if isOpener(tokens[i].text) {
+			close := closingParen(tokens, i)
+			if !strings.HasPrefix(tokens[i].text, "(?=") && !strings.HasPrefix(tokens[i].text, "(?!") && !strings.HasPrefix(tokens[i].text, "(?<=") && !strings.HasPrefix(tokens[i].text, "(?<!") {
+				low, high = widthRange(tokens[i+1 : close])
+			}
+			next = close + 1
+		} else {
+			low, high = tokenWidth(tokens[i])
+		}
+		if next < len(tokens) && isQuantifier(tokens[next].text) {
+			quantifier := tokens[next].text
+			if isLazy(quantifier) || isPossessive(quantifier) {
+				quantifier = quantifier[0 : len(quantifier)-1]
+			}
+			lower, upper := quantifierBounds(quantifier)
+			low *= lower
+			if upper == -1 && high != 0 {
+				high = -1
+			} else if high != -1 {
+				high *= upper
+			}
+			next++
+		}
+		minWidth += low
+		if maxWidth != -1 {
+			if high == -1 {
+				maxWidth = -1
+			} else {
+				maxWidth += high
+			}
+		}
+		i = next
+	}
+	return minWidth, maxWidth
+}
+
+//tokenWidth returns the fewest and the most bytes that a token other than a group or a quantifier can match
+func tokenWidth(tok token) (int, int) {
+	if tok.text == "^" || tok.text == "$" {
+		return 0, 0
+	}
+	if tok.text == "." || tok.text[0] == '[' {
+		return 1, utf8.UTFMax
+	}
+	if tok.text[0] == '\\' {
+		if char, ok := escapeChar(tok.text); ok {
+			return literalWidth(string(char), tok.flags)
+		}
+		if strcontains("AzZbB", rune(tok.text[1])) {
+			return 0, 0
+		}
+		return 1, utf8.UTFMax
+	}
+	return literalWidth(tok.text, tok.flags)
 }
 
-func deparens(tokens []string, opener, closer string) []string {
+//literalWidth returns the fewest and the most bytes that a literal can match.  Ignoring case, a character can match another case of a different length
+func literalWidth(text string, flags flags) (int, int) {
+	if flags&flagFoldCase != 0 || strings.ContainsRune(text, utf8.RuneError) {
+		chars := utf8.RuneCountInString(text)
+		return chars, chars * utf8.UTFMax
+	}
+	return len(text), len(text)
+}
+
+//inlineFlags reads a (?flags) group, or the (?flags: opening a group with its own flags, starting at index open.
+//Flags after a - are turned off, as in (?i-s).  It returns the flags in effect after it along with the length of what it read,
+//where a length of 0 means there are no flags at open
+func inlineFlags(regex string, open int, current flags) (flags, int) {
+	if !strings.HasPrefix(regex[open:len(regex)], "(?") {
+		return current, 0
+	}
+	negated := false
+	letters := 0 //how many flags have been read since the start, or since the -
+	for i := open + 2; i < len(regex); i++ {
+		if flag, ok := flagLetters[regex[i]]; ok {
+			if negated {
+				current &^= flag
+			} else {
+				current |= flag
+			}
+			letters++
+		} else if regex[i] == '-' && !negated {
+			negated = true
+			letters = 0
+		} else if (regex[i] == ')' || regex[i] == ':') && letters > 0 {
+			return current, i + 1 - open
+		} else {
+			return current, 0
+		}
+	}
+	return current, 0
+}
+
+//groupOpener reads the opening of the group that starts at index open: either a plain ( or a (? extension such as the non-capturing group (?:
+func groupOpener(regex string, open int) (string, error) {
+	if !strings.HasPrefix(regex[open:len(regex)], "(?") {
+		return "(", nil
+	}
+	for _, opener := range []string{"(?:", "(?>", "(?=", "(?!", "(?<=", "(?<!"} {
+		if strings.HasPrefix(regex[open:len(regex)], opener) {
+			return opener, nil
+		}
+	}
+	if strings.HasPrefix(regex[open:len(regex)], "(?P<") || strings.HasPrefix(regex[open:len(regex)], "(?<") {
+		end := strings.IndexByte(regex[open:len(regex)], '>')
+		if end == -1 {
+			return "", syntaxError(ErrInvalidNamedCapture, open, regex[open:len(regex)])
+		}
+		opener := regex[open : open+end+1]
+		name := groupName(opener)
+		if name == "" {
+			return "", syntaxError(ErrInvalidNamedCapture, open, opener)
+		}
+		for _, r := range name {
+			if !isWordChar(r) {
+				return "", syntaxError(ErrInvalidNamedCapture, open, opener)
+			}
+		}
+		return opener, nil
+	}
+	end := open + 3
+	if end > len(regex) {
+		end = len(regex)
+	}
+	return "", syntaxError(ErrInvalidPerlOp, open, regex[open:end])
+}
+
+//setEnd returns the index of the bracket closing the set opened at index open, or -1 if the set is never closed
+func setEnd(regex string, open int) int {
+	i := open + 1
+	if i < len(regex) && regex[i] == '^' {
+		i++
+	}
+	if i < len(regex) && regex[i] == ']' { //a leading ] is a literal
+		i++
+	}
+	for i < len(regex) {
+		if regex[i] == '\\' {
+			i += 2
+		} else if end := posixClassEnd(regex, i); end != -1 {
+			i = end
+		} else if regex[i] == ']' {
+			return i
+		} else {
+			i++
+		}
+	}
+	return -1
+}
+
+//repeatBounds reads a {n}, {n,} or {n,m} quantifier, where an upper bound of -1 means unbounded
+func repeatBounds(quantifier string) (int, int, bool) {
+	const maxRepeat = 1000
+	inner := quantifier[1 : len(quantifier)-1]
+	lower, upper := inner, inner
+	if strcontains(inner, ',') {
+		lower, upper = strSplit(inner, ',')
+	}
+	l, err := strconv.Atoi(lower)
+	if err != nil || l < 0 || l > maxRepeat {
+		return 0, 0, false
+	}
+	if upper == "" {
+		return l, -1, true
+	}
+	u, err := strconv.Atoi(upper)
+	if err != nil || u < l || u > maxRepeat {
+		return 0, 0, false
+	}
+	return l, u, true
+}
+
+//quantifierBounds returns the minimum and maximum repetitions of a greedy quantifier, where a maximum of -1 means unbounded
+func quantifierBounds(quantifier string) (int, int) {
+	if quantifier == "*" {
+		return 0, -1
+	}
+	if quantifier == "+" {
+		return 1, -1
+	}
+	if quantifier == "?" {
+		return 0, 1
+	}
+	lower, upper, _ := repeatBounds(quantifier)
+	return lower, upper
+}
+
+func isQuantifier(s string) bool {
+	return strcontains("*+?{", rune(s[0]))
+}
+
+func isLazy(quantifier string) bool {
+	return len(quantifier) > 1 && quantifier[len(quantifier)-1] == '?'
+}
+
+func isPossessive(quantifier string) bool {
+	return len(quantifier) > 1 && quantifier[len(quantifier)-1] == '+'
+}
+
+//isNamed reports whether a group opener starts a named capture group, (?P<name> or (?<name>
+func isNamed(opener string) bool {
+	return strings.HasPrefix(opener, "(?P<") || (strings.HasPrefix(opener, "(?<") && opener != "(?<=" && opener != "(?<!")
+}
+
+//groupName returns the name given by a group opener, or "" if it doesn't name a group
+func groupName(opener string) string {
+	if !isNamed(opener) {
+		return ""
+	}
+	return opener[strings.IndexByte(opener, '<')+1 : len(opener)-1]
+}
+
+//isOpener reports whether a token opens a group, which covers ( as well as the (? extensions
+func isOpener(s string) bool {
+	return s[0] == '('
+}
+
+func deparens(tokens []token, opener, closer string) []token {
 	level := 0
 	for i := len(tokens) - 1; i >= 0; i-- {
-		if tokens[i] == closer {
+		if tokens[i].text == closer {
 			level++
-		} else if tokens[i] == opener {
+		} else if strings.HasPrefix(tokens[i].text, opener) {
 			level--
 		}
 		if level == 0 {
@@ -258,7 +1140,7 @@ func deparens(tokens []string, opener, closer string) []string {
 }
 
 //like deparens but returns the tokens split in two
-func separens(tokens []string, opener, closer string) ([]string, []string) {
+func separens(tokens []token, opener, closer string) ([]token, []token) {
 	parens := deparens(tokens, opener, closer)
 	return tokens[0 : len(tokens)-len(parens)], parens
 }
@@ -282,28 +1164,3 @@ func strcontains(s string, e rune) bool {
 	}
 	return false
 }
-
-func parencontains(tokens []string, elem string) bool {
-	level := 0
-	for _, token := range tokens {
-		if token == "(" {
-			level++
-		} else if token == ")" {
-			level--
-		} else if level == 1 && token == elem {
-			return true
-		}
-		if level == 0 {
-			return false
-		}
-	}
-	return false
-}
-
-func abbreviate(tokens []string) string {
-	out := ""
-	for _, token := range tokens {
-		out += token
-	}
-	return out
-}
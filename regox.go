@@ -1,57 +1,156 @@
 package regox
 
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
 //Regex holds the expression to be used in matching
 type Regex struct {
-	expression string
-	exprTree   consumer
+	expression  string
+	exprTree    consumer
+	subexpNames []string //the name of each capture group, "" for unnamed groups; subexpNames[0] stands for the whole match
+	full        bool     //must every match cover the whole input?
 }
 
 //RegResult holds the result of a regex match
 type RegResult struct {
 	Success  bool     //did this consumption succeed?
-	Captures []string //what capture groups are there from this consumption?
+	Captures []string //what did each capture group match?  Captures[0] is the whole match and Captures[n] is the group opened by the nth parenthesis
 	Coverage string   //how much of the input string does this consumption cover?
+	groups   []int    //the start and end offsets of each capture group, -1 for a group that didn't take part in the match
+	names    []string //the name of each capture group, if known
+}
+
+//consumer is an expression node in the regular expression tree used for evaluating matches.  It takes the whole input and the position to match from,
+//along with the capture group offsets gathered so far, and calls the continuation k for every way it can match, most preferred first, until one of them succeeds
+type consumer func(input string, pos int, captures []int, k continuation) RegResult
+
+//continuation is the rest of the expression tree following a consumer.  It is called with the position the consumer stopped at and returns the result of the whole match
+type continuation func(pos int, captures []int) RegResult
+
+//match runs a consumer against the start of input and returns the first successful match
+func (cons consumer) match(input string) RegResult {
+	return cons.matchAt(input, 0)
+}
+
+//matchAt runs a consumer against input starting from pos and returns the first successful match
+func (cons consumer) matchAt(input string, pos int) RegResult {
+	return cons.run(input, pos, 0)
 }
 
-//consumer is an expression node in the regular expression tree used for evaluating matches.  It takes a string to match and returns a result
-type consumer func(string) RegResult
+//run matches a consumer containing the given number of capture groups against input starting from pos
+func (cons consumer) run(input string, pos int, groups int) RegResult {
+	return cons.exec(input, pos, groups, false)
+}
+
+//exec is like run, but when full is set it only accepts a match that reaches the end of the input, backtracking until it finds one
+func (cons consumer) exec(input string, pos int, groups int, full bool) RegResult {
+	captures := make([]int, 2*(groups+1))
+	for i := range captures {
+		captures[i] = -1
+	}
+	return cons(input, pos, captures, func(end int, captures []int) RegResult {
+		if full && end != len(input) {
+			return failure()
+		}
+		captures = append([]int{}, captures...)
+		captures[0], captures[1] = pos, end
+		return matched(input, captures)
+	})
+}
+
+//matchAt runs the regex against s starting from pos
+func (regex *Regex) matchAt(s string, pos int) RegResult {
+	return regex.exec(s, pos, regex.full)
+}
+
+//exec runs the regex against s starting from pos, only accepting a match that covers the rest of s when full is set.
+//Even if the match fails, the result has an entry in Captures for every group
+func (regex *Regex) exec(s string, pos int, full bool) RegResult {
+	res := regex.exprTree.exec(s, pos, regex.NumSubexp(), full)
+	if !res.Success {
+		res.groups = make([]int, 2*(regex.NumSubexp()+1))
+		for i := range res.groups {
+			res.groups[i] = -1
+		}
+		res.Captures = make([]string, regex.NumSubexp()+1)
+	}
+	res.names = regex.subexpNames
+	return res
+}
 
 //Match takes a string s and returns if it matches, as well as a slice of capture groups
 func (regex *Regex) Match(s string) RegResult {
-	captures := make([]string, 1)
-	captures[0] = s
-	res := regex.exprTree(s)
-	res.Captures = append(captures, res.Captures...)
-	return res
+	return regex.matchAt(s, 0)
+}
+
+//NumSubexp returns the number of capture groups in the regex, not counting the whole match
+func (regex *Regex) NumSubexp() int {
+	return len(regex.subexpNames) - 1
+}
+
+//SubexpNames returns the name of each capture group, with "" for unnamed groups.  The first name stands for the whole match and is always ""
+func (regex *Regex) SubexpNames() []string {
+	return regex.subexpNames
+}
+
+//SubexpIndex returns the number of the capture group with the given name, or -1 if there is no such group
+func (regex *Regex) SubexpIndex(name string) int {
+	if name != "" {
+		for i, subexpName := range regex.subexpNames {
+			if subexpName == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+//Matched reports whether capture group n took part in the match.  A group that didn't has "" in Captures, just like a group that matched the empty string
+func (res RegResult) Matched(n int) bool {
+	return 2*n+1 < len(res.groups) && res.groups[2*n] >= 0
+}
+
+//Named returns what each named capture group matched, leaving out groups that didn't take part in the match
+func (res RegResult) Named() map[string]string {
+	named := make(map[string]string)
+	for i, name := range res.names {
+		if name != "" && res.Matched(i) {
+			named[name] = res.Captures[i]
+		}
+	}
+	return named
+}
+
+//FullMatch is like Match, but only succeeds if the regex matches the whole of s rather than just the start of it.
+//Quantifiers and alternations backtrack as needed to reach the end of s
+func (regex *Regex) FullMatch(s string) RegResult {
+	return regex.exec(s, 0, true)
 }
 
 //Matches returns whether a given string s matches this regex
 func (regex *Regex) Matches(s string) bool {
-	return regex.exprTree(s).Success
+	return regex.Match(s).Success
 }
 
 //MatchAll returns all matches within a given string and the match indices
 func (regex *Regex) MatchAll(s string) ([]RegResult, []int) {
 	matches := make([]RegResult, 0)
 	indices := make([]int, 0)
-	i := 0
-	for i < len(s) {
-		res := regex.exprTree(s[i:len(s)])
-		if res.Success {
-			matches = append(matches, res)
-			indices = append(indices, i)
-			i += len(res.Coverage)
-		} else {
-			i++
-		}
-	}
+	regex.allMatches(s, -1, func(res RegResult) {
+		matches = append(matches, res)
+		indices = append(indices, res.groups[0])
+	})
 	return matches, indices
 }
 
 //I need to break up a regex into a composition of atomic regexes and operations
 //(\(?\d{3}\)?)* becomes star(capture(concat(option(atom("(")), repeat(digit(), 3), option(atom(")")))))
 //(asdf)? becomes option(capture(atom("asdf")))
-//each atomic operation should return the number of characters consumed, -1 if match fails, and also a slice of thus captured groups to propagate upwards
+//each operation hands what is left of the input to a continuation holding the rest of the tree, and tries its alternatives
+//in order of preference until the continuation succeeds.  This is what lets a* in a*a give back an "a" so the trailing atom can match
 //(54(63)
 //star(capture(concat(option(atom("(")), repeat(digit(), 3), option(atom(")")))))
 //star(capture(concat(option(success), repeat(yup, 3), option(failure))))
@@ -64,288 +163,555 @@ func (regex *Regex) MatchAll(s string) ([]RegResult, []int) {
 
 //Atom matches a continuous sequence of explicit characters.
 func atom(matcher string) consumer {
-	return func(input string) RegResult {
-		if len(input) < len(matcher) {
-			return failure()
-		}
-		if input[0:len(matcher)] == matcher {
-			return result(true, make([]string, 0), matcher)
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if strings.HasPrefix(input[pos:len(input)], matcher) {
+			return k(pos+len(matcher), captures)
 		}
 		return failure()
 	}
 }
 
-//Word matches any alphabetical character
-func word() consumer {
-	return func(input string) RegResult {
-		if len(input) == 0 {
+//FoldedAtom is atom ignoring case: each character matches any character in the same Unicode simple case folding orbit, so k matches K and the Kelvin sign
+func foldedAtom(matcher string) consumer {
+	return decodedAtom(matcher, equalFold)
+}
+
+//DecodedAtom is atom comparing characters decoded from the input instead of bytes, so a U+FFFD in matcher also matches a byte of invalid UTF-8 as single does
+func decodedAtom(matcher string, same func(want, char rune) bool) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		next := pos
+		for _, want := range matcher {
+			if next >= len(input) {
+				return failure()
+			}
+			char, size := utf8.DecodeRuneInString(input[next:len(input)])
+			if !same(want, char) {
+				return failure()
+			}
+			next += size
+		}
+		return k(next, captures)
+	}
+}
+
+//Single matches one character that passes the given test.  Characters are decoded from UTF-8, and each byte of invalid UTF-8 is read as utf8.RuneError
+func single(test func(rune) bool) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if pos >= len(input) {
 			return failure()
 		}
-		if input[0] >= 'A' && input[0] <= 'z' {
-			return result(true, make([]string, 0), input[0:1])
+		char, size := utf8.DecodeRuneInString(input[pos:len(input)])
+		if test(char) {
+			return k(pos+size, captures)
 		}
 		return failure()
 	}
 }
 
+//Word matches \w: a letter, digit or underscore from ASCII, [0-9A-Za-z_]
+func word() consumer {
+	return single(isWordChar)
+}
+
+//UnicodeWord matches \w in Unicode mode: a letter, mark, digit or connector such as _ from any script
+func unicodeWord() consumer {
+	return single(isUnicodeWordChar)
+}
+
 //Digit matches a singular digit of any value 0-9
 func digit() consumer {
-	return func(input string) RegResult {
-		if len(input) == 0 {
-			return failure()
-		}
-		if input[0] >= '0' && input[0] <= '9' {
-			return result(true, make([]string, 0), input[0:1])
-		}
-		return failure()
+	return single(func(char rune) bool {
+		return char >= '0' && char <= '9'
+	})
+}
+
+//UnicodeDigit matches \d in Unicode mode: a decimal digit from any script
+func unicodeDigit() consumer {
+	return single(unicode.IsDigit)
+}
+
+//UnicodeClass matches a character in the given Unicode table, for \p{...}.  With fold set it also matches the other cases of those characters
+func unicodeClass(table *unicode.RangeTable, fold bool) consumer {
+	test := func(char rune) bool {
+		return unicode.Is(table, char)
+	}
+	if fold {
+		return single(folded(test))
 	}
+	return single(test)
 }
 
 //Any matches a wild card
 func any() consumer {
-	return func(input string) RegResult {
-		if len(input) > 0 {
-			return result(true, make([]string, 0), input[0:1])
+	return single(func(char rune) bool {
+		return true
+	})
+}
+
+//NotNewline matches . outside of (?s): any character but \n
+func notNewline() consumer {
+	return single(func(char rune) bool {
+		return char != '\n'
+	})
+}
+
+//Backslash matches a backslash literal
+func backslash() consumer {
+	return single(func(char rune) bool {
+		return char == '\\'
+	})
+}
+
+//Space matches \s: a space, tab, newline, carriage return, form feed or vertical tab
+func space() consumer {
+	return single(func(char rune) bool {
+		return strcontains("	\r \n\f\v", char)
+	})
+}
+
+//UnicodeSpace matches \s in Unicode mode: any character Unicode considers white space
+func unicodeSpace() consumer {
+	return single(unicode.IsSpace)
+}
+
+//Tab matches just the tab character
+func tab() consumer {
+	return single(func(char rune) bool {
+		return char == '	'
+	})
+}
+
+//assertions
+
+//Assertion matches the empty string wherever the given test of the input and position passes
+func assertion(test func(input string, pos int) bool) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if test(input, pos) {
+			return k(pos, captures)
 		}
 		return failure()
 	}
 }
 
-//Backslash matches a backslash literal
-func backslash() consumer {
-	return func(input string) RegResult {
-		if input == "" {
+//TextStart matches \A, and ^ outside of multiline mode: the start of the input
+func textStart() consumer {
+	return assertion(func(input string, pos int) bool {
+		return pos == 0
+	})
+}
+
+//TextEnd matches \z, and $ outside of multiline mode: the end of the input
+func textEnd() consumer {
+	return assertion(func(input string, pos int) bool {
+		return pos == len(input)
+	})
+}
+
+//FinalEnd matches \Z: the end of the input, or just before a newline that ends the input
+func finalEnd() consumer {
+	return assertion(func(input string, pos int) bool {
+		return pos == len(input) || (pos == len(input)-1 && input[pos] == '\n')
+	})
+}
+
+//LineStart matches ^ in multiline mode: the start of the input or just after a newline
+func lineStart() consumer {
+	return assertion(func(input string, pos int) bool {
+		return pos == 0 || input[pos-1] == '\n'
+	})
+}
+
+//LineEnd matches $ in multiline mode: the end of the input or just before a newline
+func lineEnd() consumer {
+	return assertion(func(input string, pos int) bool {
+		return pos == len(input) || input[pos] == '\n'
+	})
+}
+
+//WordBoundary matches \b: a position with a word character, as decided by isWord, on one side of it and a non-word character or the edge of the input on the other
+func wordBoundary(isWord func(rune) bool) consumer {
+	return assertion(func(input string, pos int) bool {
+		return wordBefore(input, pos, isWord) != wordAfter(input, pos, isWord)
+	})
+}
+
+//NonWordBoundary matches \B: any position that isn't a word boundary
+func nonWordBoundary(isWord func(rune) bool) consumer {
+	return assertion(func(input string, pos int) bool {
+		return wordBefore(input, pos, isWord) == wordAfter(input, pos, isWord)
+	})
+}
+
+//Lookahead matches (?=...): the empty string wherever the contained expression matches from the current position.
+//Captures made inside it are kept, but like an atomic group it is never retried
+func lookahead(cons consumer) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		inner := captures
+		res := cons(input, pos, captures, func(next int, captures []int) RegResult {
+			inner = captures
+			return accept(next, captures)
+		})
+		if !res.Success {
 			return failure()
 		}
-		if input[0] == '\\' {
-			return result(true, make([]string, 0), input[0:1])
-		}
-		return failure()
+		return k(pos, inner)
 	}
 }
 
-//Space matches a space, newline or tab character
-func space() consumer {
-	return func(input string) RegResult {
-		if input == "" {
+//NegativeLookahead matches (?!...): the empty string wherever the contained expression doesn't match from the current position
+func negativeLookahead(cons consumer) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if cons(input, pos, captures, accept).Success {
 			return failure()
 		}
-		char := input[0]
-		if strcontains("	\r \n", rune(char)) {
-			return result(true, make([]string, 0), input[0:1])
+		return k(pos, captures)
+	}
+}
+
+//Lookbehind matches (?<=...): the empty string wherever the contained expression matches a piece of the input ending at the current position.
+//The expression matches between minWidth and maxWidth bytes, so only the starting points that far back are tried, the closest first.
+//It steps back a character at a time, so it never starts in the middle of a character but does start at each byte of invalid UTF-8
+func lookbehind(cons consumer, minWidth, maxWidth int) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		for start := pos; start >= 0 && start >= pos-maxWidth; start = previousChar(input, start) {
+			if start > pos-minWidth {
+				continue //too close for the expression to have matched yet
+			}
+			inner := captures
+			res := cons(input, start, captures, func(next int, captures []int) RegResult {
+				if next != pos {
+					return failure()
+				}
+				inner = captures
+				return accept(next, captures)
+			})
+			if res.Success {
+				return k(pos, inner)
+			}
 		}
 		return failure()
 	}
 }
 
-//Tab matches just the tab character
-func tab() consumer {
-	return func(input string) RegResult {
-		char := input[0]
-		if char == '	' {
-			return result(true, make([]string, 0), input[0:1])
+//NegativeLookbehind matches (?<!...): the empty string wherever no piece of the input ending at the current position matches the contained expression
+func negativeLookbehind(cons consumer, minWidth, maxWidth int) consumer {
+	behind := lookbehind(cons, minWidth, maxWidth)
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if behind(input, pos, captures, accept).Success {
+			return failure()
 		}
-		return failure()
+		return k(pos, captures)
 	}
 }
 
 //operations
-//? character: string may or may not contain the contained regex.  If RegResult fails, then the string doesn't match the interior regex, and nothing should be consumed.  Otherwise RegResult should consume characters and propagate captures
+//? character: string may or may not contain the contained regex.  The option first tries to match the interior regex, and if the rest of the expression fails after it, it tries again consuming nothing
 
 //Negate matches a single character that doesn't match the contained expression
 func negate(cons consumer) consumer {
-	return func(input string) RegResult {
-		if input == "" {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if pos >= len(input) || cons(input, pos, captures, accept).Success {
 			return failure()
 		}
-		res := cons(input)
-		if !res.Success {
-			return result(true, make([]string, 0), input[0:1])
-		}
-		return failure()
+		_, size := utf8.DecodeRuneInString(input[pos:len(input)])
+		return k(pos+size, captures)
 	}
 }
 
-//Set matches any char within the string chars
+//Set matches any char matched by one of the contained expressions
 func set(cons []consumer) consumer {
-	return func(input string) RegResult {
-		if input == "" {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		if pos >= len(input) {
 			return failure()
 		}
+		_, size := utf8.DecodeRuneInString(input[pos:len(input)])
 		for _, con := range cons {
-			res := con(input)
-			if res.Success {
-				return result(true, make([]string, 0), input[0:1])
+			if con(input, pos, captures, accept).Success {
+				return k(pos+size, captures)
 			}
 		}
 		return failure()
 	}
 }
 
-//Range represents a character in between the lower and upper rune.  Only used in a set.
-func inRange(lower, upper byte) consumer {
-	return func(input string) RegResult {
-		if input == "" {
-			return failure()
-		}
-		if input[0] >= lower && input[0] <= upper {
-			return result(true, make([]string, 0), input[0:1])
+//Range represents a character in between the lower and upper rune, comparing code points.  Only used in a set.
+func inRange(lower, upper rune) consumer {
+	return single(between(lower, upper))
+}
+
+//FoldedRange is inRange ignoring case: it matches a character if any of its cases is in between the lower and upper rune, so [a-c] matches B.  Only used in a set.
+func foldedRange(lower, upper rune) consumer {
+	return single(folded(between(lower, upper)))
+}
+
+//PosixClass matches a character in one of the ranges of a POSIX class like [:alpha:], given as pairs of the lowest and highest character in each range.
+//With fold set the ranges ignore case, as in foldedRange.  Only used in a set.
+func posixClass(ranges string, fold bool) consumer {
+	cons := make([]consumer, 0)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if fold {
+			cons = append(cons, foldedRange(rune(ranges[i]), rune(ranges[i+1])))
+		} else {
+			cons = append(cons, inRange(rune(ranges[i]), rune(ranges[i+1])))
 		}
-		return failure()
 	}
+	return set(cons)
 }
 
 //Option matches ?, either 0 or one of the internal expression
 func option(cons consumer) consumer {
-	return func(input string) RegResult {
-		res := cons(input)
-		if !res.Success {
-			return result(true, make([]string, 0), "")
-		}
-		return result(true, res.Captures, res.Coverage)
-	}
+	return rangeRepeat(cons, 0, 1)
 }
 
 //Repeat matches .{5}, repeats of the internal expression
 func repeat(cons consumer, repetitions int) consumer {
-	return func(input string) RegResult {
-		coverage := ""
-		captures := make([]string, 0)
-		for reps := repetitions; reps > 0; reps-- {
-			res := cons(input[len(coverage):len(input)])
-			if !res.Success {
-				return failure()
-			}
-			coverage += res.Coverage
-			captures = append(captures, res.Captures...)
-		}
-		return result(true, captures, coverage)
-	}
+	return rangeRepeat(cons, repetitions, repetitions)
 }
 
-//RangeRepeat matches a subexpression repeated anywhere from minReps to maxReps times
+//RangeRepeat matches a subexpression repeated anywhere from minReps to maxReps times, where a maxReps of -1 is unbounded.
+//It is greedy: it tries to match as many repetitions as it can, then gives them back one at a time until the rest of the expression matches
 func rangeRepeat(cons consumer, minReps, maxReps int) consumer {
-	return func(input string) RegResult {
-		coverage := ""
-		captures := make([]string, 0)
-		repsComplete := 0
-		reps := maxReps
-		if reps == -1 {
-			reps = len(input)
+	return loopRepeat(cons, minReps, maxReps, false)
+}
+
+//LazyRepeat is the lazy form of rangeRepeat, matching *?, +?, ?? and {n,m}?.
+//It tries to match as few repetitions as it can, only adding another when the rest of the expression fails to match
+func lazyRepeat(cons consumer, minReps, maxReps int) consumer {
+	return loopRepeat(cons, minReps, maxReps, true)
+}
+
+//LoopRepeat does the work of rangeRepeat and lazyRepeat.
+//Rather than nesting a call for every repetition it keeps its place in a stack of its own: for each repetition it collects every position the
+//subexpression can end at, along with the captures it ends with, then tries them in order, so a repetition over a huge input doesn't overflow the call stack.
+//The rest of the expression is tried from the longest match down, or the shortest match up when lazy
+func loopRepeat(cons consumer, minReps, maxReps int, lazy bool) consumer {
+	type end struct {
+		pos      int
+		captures []int
+	}
+	type frame struct {
+		first int //the index in ends of this repetition's first end
+		next  int //the index in ends of the next end to try
+		last  int //the index in ends just past this repetition's ends
+	}
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		ends := make([]end, 0)
+		stack := make([]frame, 0)
+		//start is where the repetition at the given depth starts: the end of the one below it that is being tried
+		start := func(depth int) end {
+			if depth == 0 {
+				return end{pos, captures}
+			}
+			return ends[stack[depth-1].next-1]
 		}
-		for ; reps > 0; reps-- {
-			res := cons(input[len(coverage):len(input)])
-			if !res.Success {
-				break
+		push := func(from end) {
+			first := len(ends)
+			if maxReps == -1 || len(stack) < maxReps {
+				cons(input, from.pos, from.captures, func(next int, captures []int) RegResult {
+					ends = append(ends, end{next, captures})
+					return failure() //keep going to collect the other ends
+				})
 			}
-			coverage += res.Coverage
-			repsComplete++
-			captures = append(captures, res.Captures...)
+			stack = append(stack, frame{first: first, next: first, last: len(ends)})
 		}
-		if repsComplete >= minReps && (maxReps == -1 || repsComplete <= maxReps) {
-			return result(true, captures, coverage)
+		push(start(0))
+		tried := false //has the rest of the expression been tried from the top repetition yet?
+		for len(stack) > 0 {
+			top := len(stack) - 1
+			reps := top
+			at := start(top)
+			if lazy && !tried && reps >= minReps {
+				tried = true
+				if res := k(at.pos, at.captures); res.Success {
+					return res
+				}
+			}
+			if stack[top].next < stack[top].last {
+				next := ends[stack[top].next]
+				stack[top].next++
+				if next.pos == at.pos {
+					//an empty repetition can be repeated forever, so stop here
+					if !lazy && reps+1 >= minReps {
+						if res := k(next.pos, next.captures); res.Success {
+							return res
+						}
+						continue
+					}
+					if lazy && reps >= minReps {
+						continue //it leaves us where we started, which has already been tried
+					}
+				}
+				push(next)
+				tried = false
+				continue
+			}
+			if !lazy && reps >= minReps {
+				if res := k(at.pos, at.captures); res.Success {
+					return res
+				}
+			}
+			ends = ends[0:stack[top].first]
+			stack = stack[0:top]
+			tried = true
 		}
 		return failure()
 	}
 }
 
-//Star matches 0 or more of the internal expression
-func star(cons consumer) consumer {
-	return func(input string) RegResult {
-		instances := 0 //how many times was the consumer satisfied?
-		index := 0     //the index to inspect the input from
-		coverage := ""
-		captures := make([]string, 0)
-		for index < len(input) {
-			res := cons(input[index:len(input)])
-			if res.Success {
-				instances++
-				coverage += res.Coverage
-				index += len(res.Coverage)
-			} else {
-				break
-			}
+//Atomic matches (?>...) and possessive quantifiers.  It commits to the first way the contained expression matches,
+//and never goes back into it to try another even if the rest of the expression fails
+func atomic(cons consumer) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		end := pos
+		inner := captures
+		res := cons(input, pos, captures, func(next int, captures []int) RegResult {
+			end, inner = next, captures
+			return accept(next, captures)
+		})
+		if !res.Success {
+			return failure()
 		}
-		return result(true, captures, coverage)
+		return k(end, inner)
 	}
 }
 
+//Star matches 0 or more of the internal expression
+func star(cons consumer) consumer {
+	return rangeRepeat(cons, 0, -1)
+}
+
 //Plus matches 1 or more of the contained expression
 func plus(cons consumer) consumer {
-	return func(input string) RegResult {
-		instances := 0 //how many times was the consumer satisfied?
-		index := 0     //the index to inspect the input from
-		coverage := ""
-		captures := make([]string, 0)
-		for index < len(input) {
-			res := cons(input[index:len(input)])
-			if res.Success {
-				instances++
-				coverage += res.Coverage
-				captures = append(captures, res.Captures...)
-				index += len(res.Coverage)
-			} else {
-				break
-			}
-		}
-		if instances > 0 {
-			return result(true, captures, coverage)
-		}
-		return failure()
-	}
+	return rangeRepeat(cons, 1, -1)
 }
 
 //Concat matches sequential regular expressions; ABC is concat(A,B,C)
 func concat(consumers ...consumer) consumer {
-	return func(input string) RegResult {
-		coverage := ""
-		captures := make([]string, 0)
-		bigstr := input
+	var step func(input string, pos int, captures []int, i int, k continuation) RegResult
+	step = func(input string, pos int, captures []int, i int, k continuation) RegResult {
+		if i == len(consumers) {
+			return k(pos, captures)
+		}
+		return consumers[i](input, pos, captures, func(next int, captures []int) RegResult {
+			return step(input, next, captures, i+1, k)
+		})
+	}
+	return func(input string, pos int, captures []int, k continuation) RegResult {
 		if len(consumers) == 0 {
 			return failure()
 		}
-		for _, cons := range consumers {
-			res := cons(bigstr)
-			bigstr = bigstr[len(res.Coverage):len(bigstr)]
-			if !res.Success {
-				return failure()
-			}
-			coverage += res.Coverage
-			captures = append(captures, res.Captures...)
-		}
-		return result(true, captures, coverage)
+		return step(input, pos, captures, 0, k)
 	}
 }
 
-//Union matches: (A|B|C) is union(A,B,C), result placed in capture group
+//Union matches: A|B|C is union(A,B,C), trying each alternative in order.  A parenthesized union is wrapped in a capture like any other group
 func union(consumers ...consumer) consumer {
-	return func(input string) RegResult {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
 		for _, cons := range consumers {
-			res := cons(input)
+			res := cons(input, pos, captures, k)
 			if res.Success {
-				return result(true, append(res.Captures, res.Coverage), res.Coverage)
+				return res
 			}
 		}
 		return failure()
 	}
 }
 
-//Capture captures information to be propagated upwards for analysis
-func capture(cons consumer) consumer {
-	return func(input string) RegResult {
-		res := cons(input)
-		if !res.Success {
-			return failure()
-		}
-		return result(true, append([]string{res.Coverage}, res.Captures...), res.Coverage)
+//Capture records where the contained expression matched as capture group number index, to be propagated upwards for analysis.
+//Inside a repetition the group keeps what it matched on the last repetition
+func capture(index int, cons consumer) consumer {
+	return func(input string, pos int, captures []int, k continuation) RegResult {
+		return cons(input, pos, captures, func(next int, inner []int) RegResult {
+			size := len(inner)
+			if size < 2*index+2 {
+				size = 2*index + 2
+			}
+			grouped := make([]int, size)
+			for i := range grouped {
+				grouped[i] = -1
+			}
+			copy(grouped, inner)
+			grouped[2*index], grouped[2*index+1] = pos, next
+			return k(next, grouped)
+		})
 	}
 }
 
 //util
-func result(success bool, captures []string, coverage string) RegResult {
-	return RegResult{Success: success, Captures: captures, Coverage: coverage}
+//matched builds the result of a successful match from its capture group offsets
+func matched(input string, groups []int) RegResult {
+	captures := make([]string, len(groups)/2)
+	for i := range captures {
+		if groups[2*i] >= 0 {
+			captures[i] = input[groups[2*i]:groups[2*i+1]]
+		}
+	}
+	return RegResult{Success: true, Captures: captures, Coverage: captures[0], groups: groups}
 }
 
 func failure() RegResult {
-	return result(false, nil, "")
+	return RegResult{Success: false}
+}
+
+//isWordChar reports whether a character is a word character for the purposes of \w and \b: [0-9A-Za-z_]
+func isWordChar(char rune) bool {
+	return (char >= '0' && char <= '9') || (char >= 'A' && char <= 'Z') || (char >= 'a' && char <= 'z') || char == '_'
+}
+
+//isUnicodeWordChar is isWordChar in Unicode mode: a letter, mark, decimal digit or connector punctuation such as _
+func isUnicodeWordChar(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsMark(char) || unicode.IsDigit(char) || unicode.Is(unicode.Pc, char)
+}
+
+func wordBefore(input string, pos int, isWord func(rune) bool) bool {
+	char, _ := utf8.DecodeLastRuneInString(input[0:pos])
+	return pos > 0 && isWord(char)
+}
+
+func wordAfter(input string, pos int, isWord func(rune) bool) bool {
+	char, _ := utf8.DecodeRuneInString(input[pos:len(input)])
+	return pos < len(input) && isWord(char)
+}
+
+//between is the test for a character in between the lower and upper rune
+func between(lower, upper rune) func(rune) bool {
+	return func(char rune) bool {
+		return char >= lower && char <= upper
+	}
+}
+
+//folded extends a test to pass a character if it passes any character in the same Unicode simple case folding orbit
+func folded(test func(rune) bool) func(rune) bool {
+	return func(char rune) bool {
+		for other := char; ; {
+			if test(other) {
+				return true
+			}
+			other = unicode.SimpleFold(other)
+			if other == char {
+				return false
+			}
+		}
+	}
+}
+
+//equalFold reports whether two characters are the same ignoring case
+func equalFold(a, b rune) bool {
+	return folded(func(char rune) bool {
+		return char == a
+	})(b)
+}
+
+//previousChar returns the index of the character before index i, where each byte of invalid UTF-8 is a character of its own, or -1 at the start of the input
+func previousChar(input string, i int) int {
+	if i == 0 {
+		return -1
+	}
+	_, size := utf8.DecodeLastRuneInString(input[0:i])
+	return i - size
+}
+
+//accept is a continuation that succeeds immediately, used to test a consumer without matching anything after it
+func accept(pos int, captures []int) RegResult {
+	return RegResult{Success: true}
 }
@@ -3,6 +3,7 @@ package regox
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -13,79 +14,79 @@ func TestAtomicMatches(t *testing.T) {
 	spaceMatcher := space()
 	tabMatcher := tab()
 	bsMatcher := backslash()
-	if !atomMatcher("asdf").Success {
+	if !atomMatcher.match("asdf").Success {
 		t.Error("'asdf' should have matched but didn't.")
 	}
-	if atomMatcher("asd").Success {
+	if atomMatcher.match("asd").Success {
 		t.Error("'asd' matched 'asdf' even though it doesn't have complete coverage")
 	}
-	if !atomMatcher("asdfa").Success {
+	if !atomMatcher.match("asdfa").Success {
 		t.Error("asdfa should have matched but didn't")
 	}
-	if atomMatcher("").Success {
+	if atomMatcher.match("").Success {
 		t.Error("the empty string should not match, but did")
 	}
-	if !atom("")("").Success {
+	if !atom("").match("").Success {
 		t.Error("the empty string should only match an empty atom")
 	}
-	if !digitMatcher("8").Success {
+	if !digitMatcher.match("8").Success {
 		t.Error("8 should match but didn't")
 	}
-	if !digitMatcher("89").Success {
+	if !digitMatcher.match("89").Success {
 		t.Error("89 did not match even though it begins with a digit")
 	}
-	if digitMatcher("asd").Success {
+	if digitMatcher.match("asd").Success {
 		t.Error("non-digits matched but shouldn't have.")
 	}
-	if digitMatcher("").Success {
+	if digitMatcher.match("").Success {
 		t.Error("the empty string should not match a digit")
 	}
-	if !anyMatcher("heyo").Success {
+	if !anyMatcher.match("heyo").Success {
 		t.Error("'heyo' should have matched any, but didn't")
 	}
-	if !anyMatcher("8560").Success {
+	if !anyMatcher.match("8560").Success {
 		t.Error("'8560' should have matched any, but didn't")
 	}
-	if anyMatcher("").Success {
+	if anyMatcher.match("").Success {
 		t.Error("'the empty string should not have matched any, but didn't")
 	}
-	if !spaceMatcher(" ").Success {
+	if !spaceMatcher.match(" ").Success {
 		t.Error("' ' should have matched but didn't.")
 	}
-	if !spaceMatcher("\n").Success {
+	if !spaceMatcher.match("\n").Success {
 		t.Error("'\\n' should have matched but didn't.")
 	}
-	if !spaceMatcher("\r").Success {
+	if !spaceMatcher.match("\r").Success {
 		t.Error("'\\r' should have matched but didn't.")
 	}
-	if !spaceMatcher("	").Success {
+	if !spaceMatcher.match("	").Success {
 		t.Error("'	' should have matched but didn't.")
 	}
-	if spaceMatcher("a").Success {
+	if spaceMatcher.match("a").Success {
 		t.Error("'a' shouldn't have matched but did.")
 	}
-	if spaceMatcher("").Success {
+	if spaceMatcher.match("").Success {
 		t.Error("'' shouldn't have matched but did.")
 	}
-	if !tabMatcher("	").Success {
+	if !tabMatcher.match("	").Success {
 		t.Error("'	' should have matched but didn't.")
 	}
-	if tabMatcher(" ").Success {
+	if tabMatcher.match(" ").Success {
 		t.Error("' ' shouldn't have matched but did.")
 	}
-	if !bsMatcher("\\").Success {
+	if !bsMatcher.match("\\").Success {
 		t.Error("'\\' should have matched but didn't.")
 	}
-	if bsMatcher("").Success {
+	if bsMatcher.match("").Success {
 		t.Error("'' shouldn't have matched but did.")
 	}
-	if bsMatcher("a").Success {
+	if bsMatcher.match("a").Success {
 		t.Error("'a' shouldn't have matched but did.")
 	}
-	if inRange('a', 'z')("").Success {
+	if inRange('a', 'z').match("").Success {
 		t.Error("lambda shouldn't have matched but did.")
 	}
-	if word()("").Success {
+	if word().match("").Success {
 		t.Error("lambda shouldn't match a word character")
 	}
 }
@@ -93,47 +94,47 @@ func TestAtomicMatches(t *testing.T) {
 func TestRepeat(t *testing.T) {
 	atomic := atom("asdf")
 	repeater := repeat(atomic, 3)
-	if !repeater("asdfasdfasdfas").Success {
+	if !repeater.match("asdfasdfasdfas").Success {
 		t.Error("3 repetitions and then some did not succeed.")
 	}
-	if repeater("").Success {
+	if repeater.match("").Success {
 		t.Error("empty string succeeded but didn't have any repetitions.")
 	}
-	if repeater("asdfasdfas").Success {
+	if repeater.match("asdfasdfas").Success {
 		t.Error("2 repetitions and then a part of the next repetition succeeded but shouldn't have")
 	}
 }
 
 func TestRangeRepeat(t *testing.T) {
 	repeater := rangeRepeat(atom("a"), 2, 4)
-	Assert(t, repeater("aa").Success, true)
-	Assert(t, repeater("a").Success, false)
-	Assert(t, repeater("aaaa").Success, true)
-	Assert(t, repeater("aaaaa").Success, true)
-	Assert(t, repeater("aaaaa").Coverage, "aaaa")
-	Assert(t, repeater("aba").Success, false)
+	Assert(t, repeater.match("aa").Success, true)
+	Assert(t, repeater.match("a").Success, false)
+	Assert(t, repeater.match("aaaa").Success, true)
+	Assert(t, repeater.match("aaaaa").Success, true)
+	Assert(t, repeater.match("aaaaa").Coverage, "aaaa")
+	Assert(t, repeater.match("aba").Success, false)
 }
 
 func TestConcat(t *testing.T) {
 	atomic1 := atom("asdf")
 	atomic2 := atom("jkl")
 	conc := concat(atomic1, atomic2)
-	if conc("").Success {
+	if conc.match("").Success {
 		t.Error("empty string passed concatenation but shouldn't have")
 	}
-	if conc("asdf").Success {
+	if conc.match("asdf").Success {
 		t.Error("first regex passed concatenation but shouldn't have")
 	}
-	if conc("jkl").Success {
+	if conc.match("jkl").Success {
 		t.Error("second regex passed concatenation but shouldn't have")
 	}
-	if !conc("asdfjkl").Success {
+	if !conc.match("asdfjkl").Success {
 		t.Error("full string didn't pass concatenation but should have")
 	}
-	if !conc("asdfjklasdf").Success {
+	if !conc.match("asdfjklasdf").Success {
 		t.Error("full string plus some didn't pass concatenation but should have")
 	}
-	if concat()("").Success {
+	if concat().match("").Success {
 		t.Error("concat of nothing should not occur.  As a result a concat of nothing should always fail")
 	}
 }
@@ -147,31 +148,31 @@ func TestOption(t *testing.T) {
 	concat2 := option(atomic1)
 	//asdf or asdfjkl should pass
 	concat3 := concat(atomic1, option(atomic2))
-	if conc("").Success {
+	if conc.match("").Success {
 		t.Error("empty string passed first concat but shouldn't have")
 	}
-	if conc("asdf").Success {
+	if conc.match("asdf").Success {
 		t.Error("optional regex passed first concat but shouldn't have")
 	}
-	if !conc("asdfjkl").Success {
+	if !conc.match("asdfjkl").Success {
 		t.Error("full string didn't pass first concat but should have")
 	}
-	if !conc("jkl").Success {
+	if !conc.match("jkl").Success {
 		t.Error("minimal string didn't pass first concat but should have")
 	}
-	if !concat2("").Success {
+	if !concat2.match("").Success {
 		t.Error("lambda didn't pass the second concat but should have")
 	}
-	if !concat2("asdf").Success {
+	if !concat2.match("asdf").Success {
 		t.Error("asdf didn't pass the second concat but should have")
 	}
-	if concat3("").Success {
+	if concat3.match("").Success {
 		t.Error("lambda passed the third concat but shouldn't have")
 	}
-	if !concat3("asdf").Success {
+	if !concat3.match("asdf").Success {
 		t.Error("asdf didn't pass the third concat but should have")
 	}
-	if !concat3("asdfjkl").Success {
+	if !concat3.match("asdfjkl").Success {
 		t.Error("asdfjkl didn't pass the third concat but should have")
 	}
 }
@@ -184,10 +185,10 @@ func TestStar(t *testing.T) {
 	//covers asdf(jkl)*
 	star2 := concat(atomic, star(atomic2))
 
-	if !cstar("").Success {
+	if !cstar.match("").Success {
 		t.Error("lambda didn't pass the first star but should have")
 	}
-	res := cstar("asdf")
+	res := cstar.match("asdf")
 	if !res.Success {
 		t.Error("asdf didn't pass the first star but should have.")
 	}
@@ -195,23 +196,23 @@ func TestStar(t *testing.T) {
 		t.Error("asdf wasn't fully captured but should have been")
 	}
 
-	if star2("").Success {
+	if star2.match("").Success {
 		t.Error("lambda passed the second star but shouldn't have")
 	}
-	if !star2("asdf").Success {
+	if !star2.match("asdf").Success {
 		t.Error("asdf didn't pass the second star but should have")
 	}
-	if !star2("asdfjkl").Success {
+	if !star2.match("asdfjkl").Success {
 		t.Error("asdfjkl didn't pass the second star but should have")
 	}
-	if !star2("asdfjkljkl").Success {
+	if !star2.match("asdfjkljkl").Success {
 		t.Error("asdfjkljkl didn't pass the second star but should have")
 	}
-	if star2("asdfjkljkl").Coverage != "asdfjkljkl" {
-		t.Error("asdfjkljkl wasn't fully covered, instead coverage: " + star2("asdfjkljkl").Coverage)
+	if star2.match("asdfjkljkl").Coverage != "asdfjkljkl" {
+		t.Error("asdfjkljkl wasn't fully covered, instead coverage: " + star2.match("asdfjkljkl").Coverage)
 	}
-	if star2("asdfjkljklyayaya").Coverage != "asdfjkljkl" {
-		t.Error("asdfjkljklyayaya had incorrect coverage: " + star2("asdfjkljklyayaya").Coverage)
+	if star2.match("asdfjkljklyayaya").Coverage != "asdfjkljkl" {
+		t.Error("asdfjkljklyayaya had incorrect coverage: " + star2.match("asdfjkljklyayaya").Coverage)
 	}
 }
 
@@ -223,10 +224,10 @@ func TestPlus(t *testing.T) {
 	//covers asdf(jkl)*
 	plus2 := concat(atomic, plus(atomic2))
 
-	if mplus("").Success {
+	if mplus.match("").Success {
 		t.Error("lambda passed the first plus but shouldn't have")
 	}
-	res := mplus("asdf")
+	res := mplus.match("asdf")
 	if !res.Success {
 		t.Error("asdf didn't pass the first plus but should have.")
 	}
@@ -234,35 +235,152 @@ func TestPlus(t *testing.T) {
 		t.Error("asdf wasn't fully captured but should have been")
 	}
 
-	if plus2("").Success {
+	if plus2.match("").Success {
 		t.Error("lambda passed the second plus but shouldn't have")
 	}
-	if plus2("asdf").Success {
+	if plus2.match("asdf").Success {
 		t.Error("asdf passed the second plus but shouldn't have")
 	}
-	if !plus2("asdfjkl").Success {
+	if !plus2.match("asdfjkl").Success {
 		t.Error("asdfjkl didn't pass the second plus but should have")
 	}
-	if !plus2("asdfjkljkl").Success {
+	if !plus2.match("asdfjkljkl").Success {
 		t.Error("asdfjkljkl didn't pass the second plus but should have")
 	}
-	if plus2("asdfjkljkl").Coverage != "asdfjkljkl" {
-		t.Error("asdfjkljkl wasn't fully covered, instead coverage: " + plus2("asdfjkljkl").Coverage)
+	if plus2.match("asdfjkljkl").Coverage != "asdfjkljkl" {
+		t.Error("asdfjkljkl wasn't fully covered, instead coverage: " + plus2.match("asdfjkljkl").Coverage)
 	}
-	if plus2("asdfjkljklyayaya").Coverage != "asdfjkljkl" {
-		t.Error("asdfjkljklyayaya had incorrect coverage: " + plus2("asdfjkljklyayaya").Coverage)
+	if plus2.match("asdfjkljklyayaya").Coverage != "asdfjkljkl" {
+		t.Error("asdfjkljklyayaya had incorrect coverage: " + plus2.match("asdfjkljklyayaya").Coverage)
 	}
 }
 
+func TestBacktracking(t *testing.T) {
+	Assert(t, concat(star(atom("a")), atom("a")).match("aaa").Coverage, "aaa")
+	Assert(t, concat(plus(atom("a")), atom("ab")).match("aaab").Coverage, "aaab")
+	Assert(t, concat(option(atom("a")), atom("a")).match("a").Success, true)
+	Assert(t, concat(rangeRepeat(atom("a"), 1, 3), atom("a")).match("aa").Coverage, "aa")
+	Assert(t, concat(union(atom("a"), atom("ab")), atom("c")).match("abc").Coverage, "abc")
+
+	r := MustCompile("(\\d+)\\d")
+	res := r.Match("123")
+	Assert(t, res.Success, true)
+	Assert(t, res.Coverage, "123")
+	Assert(t, res.Captures[1], "12")
+
+	r = MustCompile("(a|ab)(c|bcd)(d*)")
+	res = r.Match("abcd")
+	Assert(t, res.Coverage, "abcd")
+	Assert(t, res.Captures[1], "a")
+	Assert(t, res.Captures[2], "bcd")
+	Assert(t, res.Captures[3], "")
+
+	Assert(t, MustCompile(".*x").Matches("abxcdx"), true)
+	Assert(t, MustCompile(".*x").Match("abxcdx").Coverage, "abxcdx")
+	Assert(t, MustCompile("a{2,4}a").Match("aaaaa").Coverage, "aaaaa")
+	Assert(t, MustCompile("a{2,4}ab").Matches("aab"), false)
+	Assert(t, MustCompile("(a*)*b").Matches("aaab"), true)
+	Assert(t, MustCompile("(a?){3}").Matches(""), true)
+}
+
+func TestLoopRepeat(t *testing.T) {
+	Assert(t, loopRepeat(atom("a"), 0, -1, false).match("aaa").Coverage, "aaa")
+	Assert(t, loopRepeat(atom("a"), 2, 4, false).match("aaaaa").Coverage, "aaaa")
+	Assert(t, loopRepeat(atom("a"), 2, 4, false).match("a").Success, false)
+	Assert(t, concat(loopRepeat(atom("a"), 0, -1, false), atom("a")).match("aaa").Coverage, "aaa")
+	Assert(t, concat(loopRepeat(union(atom("a"), atom("ab")), 0, -1, false), atom("c")).match("abc").Coverage, "abc")
+	Assert(t, concat(loopRepeat(option(atom("a")), 3, 3, false), atom("b")).match("ab").Coverage, "ab")
+	Assert(t, loopRepeat(atom("a"), 0, -1, true).match("aaa").Coverage, "")
+	Assert(t, loopRepeat(atom("a"), 2, 4, true).match("aaaa").Coverage, "aa")
+	Assert(t, concat(loopRepeat(atom("a"), 0, -1, true), atom("b")).match("aaab").Coverage, "aaab")
+	Assert(t, concat(loopRepeat(atom("a"), 0, 2, true), atom("b")).match("aaab").Success, false)
+	Assert(t, concat(loopRepeat(union(atom("a"), atom("ab")), 0, -1, true), atom("c")).match("abc").Coverage, "abc")
+}
+
+func TestLargeInput(t *testing.T) {
+	//repetition doesn't use a call for every repetition, so it can't overflow the stack
+	log := strings.Repeat("abc def ", 125000)
+	Assert(t, fmt.Sprint(MustCompile(".*").FindAllStringIndex(log, 3)), "[[0 1000000]]")
+	Assert(t, fmt.Sprint(MustCompile("[a-z ]*").FindAllStringIndex(log, 3)), "[[0 1000000]]")
+	Assert(t, fmt.Sprint(MustCompile("^(?:abc def )+$").FindStringIndex(log)), "[0 1000000]")
+	Assert(t, fmt.Sprint(MustCompile(".*f").FindStringIndex(log)), "[0 999999]")
+	Assert(t, fmt.Sprint(MustCompile(".*?x").FindStringIndex(log+"x")), "[0 1000001]")
+	Assert(t, fmt.Sprint(MustCompile("(.*) ").FindStringSubmatchIndex(log)), "[0 1000000 0 999999]")
+	Assert(t, fmt.Sprint(MustCompile("^(\\w+ )*$").FindStringSubmatchIndex(log)), "[0 1000000 999996 1000000]")
+	Assert(t, fmt.Sprint(MustCompile("^(?:(a)|(b))*$").FindStringSubmatchIndex(strings.Repeat("ab", 500000))), "[0 1000000 999998 999999 999999 1000000]")
+	Assert(t, fmt.Sprint(MustCompile("^(\\w+? )*?$").FindStringSubmatchIndex(log)), "[0 1000000 999996 1000000]")
+}
+
+func TestLazyRepeat(t *testing.T) {
+	Assert(t, lazyRepeat(atom("a"), 0, -1).match("aaa").Coverage, "")
+	Assert(t, lazyRepeat(atom("a"), 2, 4).match("aaaa").Coverage, "aa")
+	Assert(t, lazyRepeat(atom("a"), 2, 4).match("a").Success, false)
+	Assert(t, concat(lazyRepeat(atom("a"), 0, -1), atom("b")).match("aaab").Coverage, "aaab")
+	Assert(t, concat(lazyRepeat(atom("a"), 0, 2), atom("b")).match("aaab").Success, false)
+
+	r := MustCompile("<(.+?)>")
+	res := r.Match("<a><b>")
+	Assert(t, res.Coverage, "<a>")
+	Assert(t, res.Captures[1], "a")
+	Assert(t, MustCompile("<(.+)>").Match("<a><b>").Coverage, "<a><b>")
+
+	r = MustCompile("(.*?),")
+	Assert(t, r.Match("2020-01-01,ERROR,disk full").Captures[1], "2020-01-01")
+	Assert(t, MustCompile("a??b").Match("ab").Coverage, "ab")
+	Assert(t, MustCompile("(a??)").Match("ab").Captures[1], "")
+	Assert(t, MustCompile("a{2}?").Match("aaa").Coverage, "aa")
+	Assert(t, MustCompile("a{2,}?").Match("aaaa").Coverage, "aa")
+	Assert(t, MustCompile("a{2,3}?b").Match("aaab").Coverage, "aaab")
+	Assert(t, MustCompile("a+?").Match("aaa").Coverage, "a")
+
+	_, err := Compile("a*??")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidRepeatOp)
+	_, err = Compile("a{2}??")
+	Assert(t, err.(*SyntaxError).Fragment, "{2}??")
+}
+
+func TestAtomic(t *testing.T) {
+	Assert(t, concat(atomic(star(atom("a"))), atom("a")).match("aaa").Success, false)
+	Assert(t, concat(atomic(union(atom("a"), atom("ab"))), atom("c")).match("abc").Success, false)
+	Assert(t, concat(atomic(union(atom("ab"), atom("a"))), atom("c")).match("abc").Success, true)
+	Assert(t, atomic(atom("b")).match("a").Success, false)
+
+	Assert(t, MustCompile("a*+a").Matches("aaa"), false)
+	Assert(t, MustCompile("a*+b").Match("aaab").Coverage, "aaab")
+	Assert(t, MustCompile("a++").Match("aaa").Coverage, "aaa")
+	Assert(t, MustCompile("a++a").Matches("aaa"), false)
+	Assert(t, MustCompile("a?+a").Matches("a"), false)
+	Assert(t, MustCompile("a?+a").Matches("aa"), true)
+	Assert(t, MustCompile("a{1,3}+a").Matches("aaa"), false)
+	Assert(t, MustCompile("a{1,3}+a").Matches("aaaa"), true)
+	Assert(t, MustCompile("\\d++-").Match("123-").Coverage, "123-")
+	Assert(t, MustCompile("(?>a|ab)c").Matches("abc"), false)
+	Assert(t, MustCompile("(?>ab|a)c").Matches("abc"), true)
+	Assert(t, MustCompile("(?>a+)b").Matches("aab"), true)
+	Assert(t, len(MustCompile("(?>a)(b)").Match("ab").Captures), 2)
+
+	_, err := Compile("a*+?")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidRepeatOp)
+	_, err = Compile("a+?+")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidRepeatOp)
+	_, err = Compile("(?>")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingParen)
+	_, err = Compile("(?>*)")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	_, err = Compile("a(?%b)")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidPerlOp)
+	Assert(t, err.(*SyntaxError).Fragment, "(?%")
+}
+
 func TestUnion(t *testing.T) {
 	atom1 := atom("gaben")
 	atom2 := atom("heidi")
 	munion := union(atom1, atom2)
-	emptyResult := munion("")
-	firstResult := munion("gaben")
-	secondResult := munion("heidi")
-	compoundResult := munion("gabenheidi")
-	garbageResult := munion("heyo I'm a rockstar")
+	emptyResult := munion.match("")
+	firstResult := munion.match("gaben")
+	secondResult := munion.match("heidi")
+	compoundResult := munion.match("gabenheidi")
+	garbageResult := munion.match("heyo I'm a rockstar")
 	if emptyResult.Success {
 		t.Error("lambda passed the union but shouldn't have")
 	}
@@ -287,14 +405,14 @@ func TestCapture(t *testing.T) {
 	atom1 := atom("a")
 	atom2 := atom("bc")
 	//(a*)bc
-	capt := capture(star(atom1))
+	capt := capture(1, star(atom1))
 	expr := concat(capt, atom2)
-	if expr("").Success {
+	if expr.match("").Success {
 		t.Error("lambda passed but shouldn't have")
 	}
-	res1 := expr("")
-	res2 := expr("bc")
-	res3 := expr("abc")
+	res1 := expr.run("", 0, 1)
+	res2 := expr.run("bc", 0, 1)
+	res3 := expr.run("abc", 0, 1)
 
 	if res1.Success {
 		t.Error("lambda passed but shouldn't have")
@@ -302,20 +420,21 @@ func TestCapture(t *testing.T) {
 	if !res2.Success {
 		t.Error("empty capture failed but should have passed")
 	}
-	if len(res2.Captures) == 0 {
-		t.Error("captures of empty capture does not contain empty string but should")
+	if len(res2.Captures) != 2 {
+		t.Error("captures of empty capture does not contain the match and the empty string but should")
 	}
-	if res2.Captures[0] != "" {
-		t.Error("captures of empty capture does not contain empty string, but rather " + res2.Captures[0])
+	if res2.Captures[1] != "" {
+		t.Error("captures of empty capture does not contain empty string, but rather " + res2.Captures[1])
 	}
-	if len(res3.Captures) != 1 {
-		t.Error("captures of abc should just contain a, but instead has " + strconv.Itoa(len(res3.Captures)) + " elements")
+	if len(res3.Captures) != 2 || res3.Captures[0] != "abc" || res3.Captures[1] != "a" {
+		t.Error(fmt.Sprint("captures of abc should just contain the match and a, but instead has ", res3.Captures))
 	}
 
-	compCapt := capture(concat(atom1, option(capture(atom2))))
-	res1 = compCapt("")
-	res2 = compCapt("a")
-	res3 = compCapt("abc")
+	//(a(bc)?)
+	compCapt := capture(1, concat(atom1, option(capture(2, atom2))))
+	res1 = compCapt.run("", 0, 2)
+	res2 = compCapt.run("a", 0, 2)
+	res3 = compCapt.run("abc", 0, 2)
 
 	if res1.Success {
 		t.Error("lambda passed comp capt but shouldn't have")
@@ -323,37 +442,252 @@ func TestCapture(t *testing.T) {
 	if !res2.Success {
 		t.Error("a should pass comp capt but doesn't")
 	}
-	if len(res2.Captures) != 1 {
-		t.Error("a should have one capture group but instead has " + strconv.Itoa(len(res2.Captures)))
+	if len(res2.Captures) != 3 {
+		t.Error("a should have two capture groups but instead has " + strconv.Itoa(len(res2.Captures)-1))
 	}
-	if res2.Captures[0] != "a" {
-		t.Error("the first capture group of a should be a but is instead " + res2.Captures[0])
+	if res2.Captures[1] != "a" || res2.Captures[2] != "" {
+		t.Error(fmt.Sprint("a should have captures [a, ] but instead has ", res2.Captures[1:]))
 	}
-	if len(res3.Captures) != 2 {
-		t.Error("abc should have 2 capture groups but instead has " + strconv.Itoa(len(res3.Captures)))
+	if len(res3.Captures) != 3 {
+		t.Error("abc should have 2 capture groups but instead has " + strconv.Itoa(len(res3.Captures)-1))
 	}
-	if res3.Captures[0] != "abc" || res3.Captures[1] != "bc" {
-		t.Error(fmt.Sprint("abc should have captures [abc, bc] but instead has ", res3.Captures))
+	if res3.Captures[1] != "abc" || res3.Captures[2] != "bc" {
+		t.Error(fmt.Sprint("abc should have captures [abc, bc] but instead has ", res3.Captures[1:]))
 	}
 
-	crazyCapt := capture(repeat(capture(atom("abc")), 3))
-	res := crazyCapt("abcabcabc")
-	if res.Captures[0] != "abcabcabc" || res.Captures[1] != "abc" {
-		t.Error(fmt.Sprint("abcabcabc should have captures [abcabcabc, abc] but instead has ", res.Captures))
+	crazyCapt := capture(1, repeat(capture(2, atom("abc")), 3))
+	res := crazyCapt.match("abcabcabc")
+	if res.Captures[1] != "abcabcabc" || res.Captures[2] != "abc" {
+		t.Error(fmt.Sprint("abcabcabc should have captures [abcabcabc, abc] but instead has ", res.Captures[1:]))
+	}
+}
+
+func TestCaptureNumbering(t *testing.T) {
+	r := MustCompile("((a)(b(c)?))")
+	Assert(t, r.NumSubexp(), 4)
+	res := r.Match("ab")
+	Assert(t, fmt.Sprint(res.Captures), "[ab ab a b ]")
+	Assert(t, res.Matched(3), true)
+	Assert(t, res.Matched(4), false)
+	Assert(t, res.Matched(5), false)
+	Assert(t, fmt.Sprint(r.Match("abc").Captures), "[abc abc a bc c]")
+
+	//groups hold what they matched on the last repetition, whichever quantifier repeats them
+	Assert(t, MustCompile("(a|b)*").Match("abb").Captures[1], "b")
+	Assert(t, MustCompile("(a|b)*c").Match("abac").Captures[1], "a")
+	Assert(t, MustCompile("(\\d)+").Match("123").Captures[1], "3")
+	Assert(t, MustCompile("(\\d){2}").Match("123").Captures[1], "2")
+	Assert(t, MustCompile("(\\d){1,}?x").Match("12x").Captures[1], "2")
+	Assert(t, len(MustCompile("(\\d)+").Match("123").Captures), 2)
+	res = MustCompile("(?:(a)|b)+").Match("ab")
+	Assert(t, res.Captures[1], "a")
+
+	//groups that never matched, or only matched on a path that was backtracked out of, are unmatched
+	res = MustCompile("(a)*").Match("")
+	Assert(t, res.Success, true)
+	Assert(t, len(res.Captures), 2)
+	Assert(t, res.Matched(1), false)
+	res = MustCompile("(a)|(b)").Match("b")
+	Assert(t, fmt.Sprint(res.Captures), "[b  b]")
+	Assert(t, res.Matched(1), false)
+	Assert(t, res.Matched(2), true)
+	res = MustCompile("(?:(a)x|ay)").Match("ay")
+	Assert(t, res.Success, true)
+	Assert(t, res.Matched(1), false)
+	res = MustCompile("(a)()").Match("a")
+	Assert(t, res.Matched(2), true)
+	Assert(t, res.Captures[2], "")
+
+	res = MustCompile("(a)(b)").Match("xy")
+	Assert(t, res.Success, false)
+	Assert(t, len(res.Captures), 3)
+	Assert(t, res.Matched(0), false)
+	Assert(t, MustCompile("abc").NumSubexp(), 0)
+	Assert(t, len(MustCompile("abc").Match("abc").Captures), 1)
+}
+
+func TestNonCapturingGroup(t *testing.T) {
+	r := MustCompile("(?:ab)+(c)")
+	res := r.Match("ababc")
+	Assert(t, res.Coverage, "ababc")
+	Assert(t, len(res.Captures), 2)
+	Assert(t, res.Captures[1], "c")
+	Assert(t, len(r.SubexpNames()), 2)
+
+	r = MustCompile("(?:a|b)(?:c|d(e))")
+	res = r.Match("bde")
+	Assert(t, res.Success, true)
+	Assert(t, res.Captures[1], "e")
+	Assert(t, r.Matches("ac"), true)
+	Assert(t, MustCompile("(?:)").Matches(""), true)
+	Assert(t, MustCompile("x(?:y)?z").Matches("xz"), true)
+	_, err := Compile("(?:*a)")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+}
+
+func TestNamedCapture(t *testing.T) {
+	r := MustCompile("(?P<key>\\w+)=(\\d*)(?<unit>[a-z]+)?")
+	Assert(t, fmt.Sprint(r.SubexpNames()), "[ key  unit]")
+	Assert(t, r.SubexpIndex("key"), 1)
+	Assert(t, r.SubexpIndex("unit"), 3)
+	Assert(t, r.SubexpIndex("value"), -1)
+	Assert(t, r.SubexpIndex(""), -1)
+
+	res := r.Match("timeout=30s")
+	Assert(t, res.Success, true)
+	Assert(t, res.Captures[r.SubexpIndex("key")], "timeout")
+	Assert(t, res.Captures[r.SubexpIndex("unit")], "s")
+	named := res.Named()
+	Assert(t, len(named), 2)
+	Assert(t, named["key"], "timeout")
+	Assert(t, named["unit"], "s")
+
+	named = r.Match("retries=3").Named()
+	Assert(t, len(named), 1)
+	Assert(t, named["key"], "retries")
+	_, ok := named["unit"]
+	Assert(t, ok, false)
+
+	Assert(t, len(r.Match("=").Named()), 0)
+	Assert(t, MustCompile("(?<a>x)(?<=x)(?<b>y)").Match("xy").Named()["b"], "y")
+
+	for _, bad := range []string{"(?P<>a)", "(?P<a-b>a)", "(?<a", "(?P<a>x)(?P<a>y)"} {
+		_, err := Compile(bad)
+		if err == nil {
+			t.Error("compiling " + bad + " should have failed but didn't")
+		} else {
+			Assert(t, err.(*SyntaxError).Kind, ErrInvalidNamedCapture)
+		}
 	}
 }
 
+func TestAnchors(t *testing.T) {
+	Assert(t, textStart().matchAt("ab", 0).Success, true)
+	Assert(t, textStart().matchAt("ab", 1).Success, false)
+	Assert(t, textEnd().matchAt("ab", 2).Success, true)
+	Assert(t, textEnd().matchAt("ab\n", 2).Success, false)
+	Assert(t, finalEnd().matchAt("ab\n", 2).Success, true)
+	Assert(t, finalEnd().matchAt("ab\nc", 2).Success, false)
+	Assert(t, lineStart().matchAt("a\nb", 2).Success, true)
+	Assert(t, lineStart().matchAt("a\nb", 1).Success, false)
+	Assert(t, lineEnd().matchAt("a\nb", 1).Success, true)
+	Assert(t, lineEnd().matchAt("a\nb", 3).Success, true)
+	Assert(t, lineEnd().matchAt("a\nb", 0).Success, false)
+
+	r := MustCompile("^\\d{3}$")
+	Assert(t, r.Matches("123"), true)
+	Assert(t, r.Matches("123abc"), false)
+	Assert(t, r.Matches("123\n"), false)
+	Assert(t, MustCompile("^\\d{3}\\Z").Matches("123\n"), true)
+	Assert(t, MustCompile("\\A\\d+\\z").Match("42").Coverage, "42")
+	Assert(t, MustCompile("a|^b").Matches("b"), true)
+
+	_, indices := MustCompile("^\\w").MatchAll("ab\ncd\nef")
+	Assert(t, fmt.Sprint(indices), "[0]")
+	_, indices = MustCompile("(?m)^\\w").MatchAll("ab\ncd\nef")
+	Assert(t, fmt.Sprint(indices), "[0 3 6]")
+	results, indices := MustCompile("(?m)\\w+$").MatchAll("ab\ncd\nef")
+	Assert(t, fmt.Sprint(indices), "[0 3 6]")
+	Assert(t, results[1].Coverage, "cd")
+	_, indices = MustCompile("\\w+$").MatchAll("ab\ncd\nef")
+	Assert(t, fmt.Sprint(indices), "[6]")
+	_, indices = MustCompile("(?m)\\A\\w").MatchAll("ab\ncd")
+	Assert(t, fmt.Sprint(indices), "[0]")
+
+	//the flag only lasts until the end of its group
+	Assert(t, MustCompile("((?m)$)\n$").Matches("\n"), true)
+	Assert(t, MustCompile("((?m)$)\n$").Matches("\n\n"), false)
+
+	_, err := Compile("(?m)*a")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	_, err = Compile("(?)")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidPerlOp)
+}
+
+func TestWordBoundary(t *testing.T) {
+	Assert(t, wordBoundary(isWordChar).matchAt("ab cd", 0).Success, true)
+	Assert(t, wordBoundary(isWordChar).matchAt("ab cd", 1).Success, false)
+	Assert(t, wordBoundary(isWordChar).matchAt("ab cd", 2).Success, true)
+	Assert(t, wordBoundary(isWordChar).matchAt("ab cd", 5).Success, true)
+	Assert(t, wordBoundary(isWordChar).matchAt("", 0).Success, false)
+	Assert(t, nonWordBoundary(isWordChar).matchAt("ab cd", 1).Success, true)
+	Assert(t, nonWordBoundary(isWordChar).matchAt("ab cd", 3).Success, false)
+	Assert(t, nonWordBoundary(isWordChar).matchAt("", 0).Success, true)
+
+	_, indices := MustCompile("\\bcat\\b").MatchAll("cat concat cat_ cats cat.")
+	Assert(t, fmt.Sprint(indices), "[0 21]")
+	_, indices = MustCompile("\\Bcat").MatchAll("cat concat cat_ cats cat.")
+	Assert(t, fmt.Sprint(indices), "[7]")
+	Assert(t, MustCompile("\\b").Matches("b"), true)
+	Assert(t, MustCompile("\\b").Match("b").Coverage, "")
+	Assert(t, MustCompile("x\\b").Matches("x9"), false)
+	Assert(t, MustCompile("x\\b").Matches("x-"), true)
+}
+
+func TestLookaround(t *testing.T) {
+	Assert(t, concat(lookahead(atom("ab")), atom("a")).match("ab").Coverage, "a")
+	Assert(t, lookahead(atom("b")).match("ab").Success, false)
+	Assert(t, negativeLookahead(atom("b")).match("ab").Success, true)
+	Assert(t, negativeLookahead(atom("a")).match("ab").Success, false)
+	Assert(t, lookbehind(atom("a"), 1, 1).matchAt("ab", 1).Success, true)
+	Assert(t, lookbehind(atom("b"), 1, 1).matchAt("ab", 1).Success, false)
+	Assert(t, lookbehind(atom("a"), 1, 1).matchAt("ab", 0).Success, false)
+	Assert(t, negativeLookbehind(atom("a"), 1, 1).matchAt("ab", 1).Success, false)
+	Assert(t, negativeLookbehind(atom("a"), 1, 1).matchAt("ab", 0).Success, true)
+
+	r := MustCompile("\\w+(?=,)")
+	Assert(t, r.Match("alpha,beta").Coverage, "alpha")
+	Assert(t, r.Matches("alpha"), false)
+	results, indices := MustCompile("q(?!u)").MatchAll("quit qat qi")
+	Assert(t, fmt.Sprint(indices), "[5 9]")
+	Assert(t, results[0].Coverage, "q")
+	results, _ = MustCompile("(?<=\\$)\\d+").MatchAll("cost: $42 or 17")
+	Assert(t, len(results), 1)
+	Assert(t, results[0].Coverage, "42")
+	_, indices = MustCompile("(?<!-)\\b\\d+").MatchAll("-3 4 -5 66")
+	Assert(t, fmt.Sprint(indices), "[3 8]")
+	Assert(t, MustCompile("a(?<=[a-c]{2}a)b").Matches("a"), false)
+	_, indices = MustCompile("a(?<=ba{1,3})").MatchAll("baaa caa")
+	Assert(t, fmt.Sprint(indices), "[1 2 3]")
+	Assert(t, MustCompile("(?<=ab|c|(?:de){2})x").FindString("dedex"), "x")
+	Assert(t, MustCompile("(?<=ab|c|(?:de){2})x").FindString("dex"), "")
+	Assert(t, MustCompile("(?<=^|,)\\w").FindString("a,b"), "a")
+	Assert(t, MustCompile("(?<=é)x").FindString("éx"), "x")
+	Assert(t, MustCompile("(?i)(?<=k)x").FindString("\u212ax"), "x")
+	Assert(t, MustCompile("(?<=[é-ü]{2})x").FindString("éüx"), "x")
+	Assert(t, MustCompile("(?=(\\d+))\\d").Match("123").Captures[1], "123")
+	Assert(t, MustCompile("(?=a)*b").Matches("b"), true)
+
+	_, err := Compile("(?<=a")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingParen)
+	_, err = Compile("x(?<=ba+)")
+	Assert(t, err.(*SyntaxError).Kind, ErrUnboundedLookbehind)
+	Assert(t, err.(*SyntaxError).Offset, 1)
+	Assert(t, err.(*SyntaxError).Fragment, "(?<=ba+)")
+	_, err = Compile("(?<!a|b*)")
+	Assert(t, err.(*SyntaxError).Kind, ErrUnboundedLookbehind)
+	_, err = Compile("(?<=(?=a*)b)")
+	Assert(t, err, nil)
+
+	//only the starting points the lookbehind's length allows are tried, so a long input without a match is quick
+	long := strings.Repeat("b", 100000)
+	Assert(t, MustCompile("(?<=a)b").FindStringIndex(long) == nil, true)
+	Assert(t, len(MustCompile("(?<!a)c").FindAllStringIndex(long, -1)), 0)
+	_, err = Compile("(?<a)")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidNamedCapture)
+}
+
 func TestNegate(t *testing.T) {
 	negater := negate(space())
-	Assert(t, negater("a").Success, true)
-	Assert(t, negater(" ").Success, false)
-	Assert(t, negater("").Success, false)
+	Assert(t, negater.match("a").Success, true)
+	Assert(t, negater.match(" ").Success, false)
+	Assert(t, negater.match("").Success, false)
 }
 
 func TestParse(t *testing.T) {
 	rgx := Parse("a+")
 	ergx := plus(atom("a"))
-	success2 := ergx("aa").Success
+	success2 := ergx.match("aa").Success
 	success := rgx.Match("aa").Success
 	if success != success2 {
 		t.Error("lambda matched but shouldn't have")
@@ -374,7 +708,117 @@ func TestParse(t *testing.T) {
 }
 
 func TestSetTokenize(t *testing.T) {
-	Assert(t, fmt.Sprint(setTokenize("a-z-A-Z\\\\asA-zdf\\d.\\.-")), fmt.Sprint("[a-z - A-Z \\\\ a s A-z d f \\d . \\. -]"))
+	tokens, err := setTokenize("a-z-A-Z\\\\asA-zdf\\d.\\.-")
+	Assert(t, err, nil)
+	Assert(t, fmt.Sprint(tokens), fmt.Sprint("[a-z - A-Z \\\\ a s A-z d f \\d . \\. -]"))
+	_, err = setTokenize("z-a")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidCharRange)
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		regex    string
+		kind     ErrorKind
+		offset   int
+		fragment string
+	}{
+		{"(ab", ErrMissingParen, 0, "(ab"},
+		{"a(b(c)", ErrMissingParen, 1, "(b(c)"},
+		{"ab)", ErrUnexpectedParen, 2, "ab)"},
+		{"a[bc", ErrMissingBracket, 1, "[bc"},
+		{"[]", ErrMissingBracket, 0, "[]"},
+		{"a{2", ErrMissingBrace, 1, "{2"},
+		{"a{x,3}", ErrInvalidRepeatSize, 1, "{x,3}"},
+		{"a{3,2}", ErrInvalidRepeatSize, 1, "{3,2}"},
+		{"a{,3}", ErrInvalidRepeatSize, 1, "{,3}"},
+		{"ab\\", ErrTrailingBackslash, 2, "\\"},
+		{"*a", ErrMissingRepeatArgument, 0, "*"},
+		{"(+a)", ErrMissingRepeatArgument, 1, "+"},
+		{"a|?", ErrMissingRepeatArgument, 2, "?"},
+		{"a**", ErrInvalidRepeatOp, 1, "**"},
+		{"a{2}{3}", ErrInvalidRepeatOp, 1, "{2}{3}"},
+		{"x[b-a]", ErrInvalidCharRange, 2, "b-a"},
+		{"a\\p{Klingon}", ErrInvalidCharClass, 1, "\\p{Klingon}"},
+		{"\\p{L", ErrInvalidCharClass, 0, "\\p{L"},
+		{"\\pX", ErrInvalidCharClass, 0, "\\pX"},
+		{"[a\\p{Nope}]", ErrInvalidCharClass, 2, "\\p{Nope}"},
+		{"[a-\\pL]", ErrInvalidCharRange, 1, "a-\\pL"},
+		{"[[:alfa:]]", ErrInvalidCharRange, 1, "[:alfa:]"},
+		{"[a-[:digit:]]", ErrInvalidCharRange, 1, "a-[:digit:]"},
+		{"a\\x4", ErrInvalidEscape, 1, "\\x"},
+		{"\\xzz", ErrInvalidEscape, 0, "\\x"},
+		{"\\x{41", ErrInvalidEscape, 0, "\\x{41"},
+		{"\\x{110000}", ErrInvalidEscape, 0, "\\x{110000}"},
+		{"\\x{D800}", ErrInvalidEscape, 0, "\\x{D800}"},
+		{"\\x{}", ErrInvalidEscape, 0, "\\x{}"},
+		{"\\u12", ErrInvalidEscape, 0, "\\u"},
+		{"(a)\\1", ErrInvalidEscape, 3, "\\1"},
+		{"[a\\x{zz}]", ErrInvalidEscape, 2, "\\x{zz}"},
+		{"[\\x5a-\\x41]", ErrInvalidCharRange, 1, "\\x5a-\\x41"},
+		{"[\\A]", ErrInvalidEscape, 1, "\\A"},
+		{"x[a\\z]", ErrInvalidEscape, 3, "\\z"},
+		{"[^\\Z]", ErrInvalidEscape, 2, "\\Z"},
+		{"[x\\B]", ErrInvalidEscape, 2, "\\B"},
+		{"\\q", ErrInvalidEscape, 0, "\\q"},
+		{"a\\8", ErrInvalidEscape, 1, "\\8"},
+		{"\\e", ErrInvalidEscape, 0, "\\e"},
+		{"x\\é", ErrInvalidEscape, 1, "\\é"},
+		{"[a\\y]", ErrInvalidEscape, 2, "\\y"},
+		{"[\\Q]", ErrInvalidEscape, 1, "\\Q"},
+		{"(?z)", ErrInvalidPerlOp, 0, "(?z"},
+		{"(?)", ErrInvalidPerlOp, 0, "(?)"},
+		{"a(?i-)", ErrInvalidPerlOp, 1, "(?i"},
+		{"(?--i)", ErrInvalidPerlOp, 0, "(?-"},
+	}
+	for _, c := range cases {
+		rgx, err := Compile(c.regex)
+		if err == nil {
+			t.Error("compiling " + c.regex + " should have failed but didn't")
+			continue
+		}
+		if rgx != nil {
+			t.Error("a failed compile of " + c.regex + " should not return a regex")
+		}
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Error(fmt.Sprint("compiling ", c.regex, " returned a ", err, " instead of a *SyntaxError"))
+			continue
+		}
+		Assert(t, serr.Kind, c.kind)
+		Assert(t, serr.Offset, c.offset)
+		Assert(t, serr.Fragment, c.fragment)
+	}
+	_, err := Compile("(a|b)[]a-c]{2,3}\\\\.\\\\")
+	Assert(t, err, nil)
+}
+
+func TestMustCompile(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustCompile should have panicked on a malformed regex")
+		}
+	}()
+	Assert(t, MustCompile("a+").Matches("aa"), true)
+	MustCompile("a(")
+}
+
+func TestQuantifierBinding(t *testing.T) {
+	r := MustCompile("abc*")
+	Assert(t, r.Match("abccc").Coverage, "abccc")
+	Assert(t, r.Matches("ab"), true)
+	Assert(t, r.Match("abcabc").Coverage, "abc")
+	r = MustCompile("xy{2}")
+	Assert(t, r.Matches("xyy"), true)
+	Assert(t, r.Matches("xyxy"), false)
+}
+
+func TestTopLevelUnion(t *testing.T) {
+	r := MustCompile("cat|dog")
+	Assert(t, r.Matches("dog"), true)
+	Assert(t, r.Matches("cat"), true)
+	Assert(t, r.Matches("cat|dog"), true)
+	Assert(t, r.Match("cat|dog").Coverage, "cat")
+	Assert(t, len(r.Match("dog").Captures), 1)
 }
 
 func TestManyRegexes(t *testing.T) {
@@ -430,7 +874,7 @@ func TestManyRegexes(t *testing.T) {
 	Assert(t, success, true)
 
 	r1 = Parse("(asdf|h(i|j)k)\\w\\W")
-	success = r1.Match("hjka9").Success
+	success = r1.Match("hjka!").Success
 	Assert(t, success, true)
 
 	r1 = Parse("a{2,5}")
@@ -455,6 +899,437 @@ func TestMatchAll(t *testing.T) {
 	Assert(t, indices[2], 13)
 }
 
+func TestFullMatch(t *testing.T) {
+	r := MustCompile("\\d{3}")
+	Assert(t, r.FullMatch("123").Success, true)
+	Assert(t, r.FullMatch("123abc").Success, false)
+	Assert(t, r.FullMatch("x123").Success, false)
+	Assert(t, r.Matches("123abc"), true)
+
+	//the tree backtracks to reach the end rather than giving up on its first match
+	Assert(t, MustCompile("\\d+?").FullMatch("123").Coverage, "123")
+	Assert(t, MustCompile("a|ab").FullMatch("ab").Success, true)
+	Assert(t, MustCompile("(a|ab)(c|bcd)").FullMatch("abcd").Captures[2], "bcd")
+	Assert(t, MustCompile("(\\w+?)(\\d*)").FullMatch("abc42").Captures[1], "abc")
+	Assert(t, MustCompile("a*+").FullMatch("aab").Success, false)
+	Assert(t, MustCompile("").FullMatch("").Success, true)
+	Assert(t, len(r.FullMatch("12").Captures), 1)
+
+	postcode := MustCompile("[A-Z]{2}\\d{1,2} \\d[A-Z]{2}", MatchFull)
+	Assert(t, postcode.Matches("SW1 2AB"), true)
+	Assert(t, postcode.Matches("SW1 2AB and more"), false)
+	Assert(t, postcode.Match("SW12 3CD").Coverage, "SW12 3CD")
+	Assert(t, postcode.FindString("at SW1 2AB"), "")
+	Assert(t, postcode.FindStringIndex("SW1 2AB") != nil, true)
+	Assert(t, len(postcode.FindAllString("SW1 2AB", -1)), 1)
+	_, err := Compile("(", MatchFull)
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingParen)
+}
+
+func TestFindIndex(t *testing.T) {
+	r := MustCompile("(\\w+)@(\\w+)(\\.com)?")
+	Assert(t, fmt.Sprint(r.FindIndex([]byte("mail bob@example.org now"))), "[5 16]")
+	Assert(t, fmt.Sprint(r.FindStringIndex("mail bob@example.org now")), "[5 16]")
+	Assert(t, fmt.Sprint(r.FindStringSubmatchIndex("mail bob@example.org now")), "[5 16 5 8 9 16 -1 -1]")
+	Assert(t, fmt.Sprint(r.FindSubmatchIndex([]byte("x a@b.com"))), "[2 9 2 3 4 5 5 9]")
+	Assert(t, r.FindStringIndex("no address") == nil, true)
+	Assert(t, r.FindStringSubmatchIndex("no address") == nil, true)
+	Assert(t, fmt.Sprint(MustCompile("$").FindStringIndex("ab")), "[2 2]")
+	Assert(t, fmt.Sprint(MustCompile("a*").FindStringIndex("baa")), "[0 0]")
+
+	all := r.FindAllStringSubmatchIndex("a@b c@d.com e@f", -1)
+	Assert(t, fmt.Sprint(all), "[[0 3 0 1 2 3 -1 -1] [4 11 4 5 6 7 7 11] [12 15 12 13 14 15 -1 -1]]")
+	Assert(t, len(r.FindAllSubmatchIndex([]byte("a@b c@d.com e@f"), 2)), 2)
+	Assert(t, len(r.FindAllStringSubmatchIndex("a@b c@d.com e@f", 0)), 0)
+	Assert(t, r.FindAllStringSubmatchIndex("nothing", -1) == nil, true)
+
+	results, indices := MustCompile("a*").MatchAll("baab")
+	Assert(t, fmt.Sprint(indices), "[0 1 4]")
+	Assert(t, results[1].Coverage, "aa")
+}
+
+func TestFind(t *testing.T) {
+	r := MustCompile("(\\d+)-(\\d+)?")
+	Assert(t, string(r.Find([]byte("ports 80-90 and 8080-"))), "80-90")
+	Assert(t, r.Find([]byte("no ports")) == nil, true)
+	Assert(t, r.FindString("ports 80-90 and 8080-"), "80-90")
+	Assert(t, r.FindString("no ports"), "")
+	Assert(t, fmt.Sprint(r.FindStringSubmatch("and 8080-")), "[8080- 8080 ]")
+	Assert(t, r.FindStringSubmatch("no ports") == nil, true)
+	sub := r.FindSubmatch([]byte("and 8080-"))
+	Assert(t, len(sub), 3)
+	Assert(t, string(sub[1]), "8080")
+	Assert(t, sub[2] == nil, true)
+	Assert(t, r.FindSubmatch([]byte("none")) == nil, true)
+
+	words := MustCompile("\\w+")
+	Assert(t, fmt.Sprint(words.FindAllString("one two three four", -1)), "[one two three four]")
+	Assert(t, fmt.Sprint(words.FindAllString("one two three four", 3)), "[one two three]")
+	Assert(t, words.FindAllString("one two three four", 0) == nil, true)
+	Assert(t, words.FindAllString("   ", -1) == nil, true)
+	Assert(t, len(words.FindAll([]byte("one two three"), 2)), 2)
+	Assert(t, string(words.FindAll([]byte("one two three"), -1)[2]), "three")
+	Assert(t, fmt.Sprint(words.FindAllIndex([]byte("one two"), -1)), "[[0 3] [4 7]]")
+	Assert(t, fmt.Sprint(words.FindAllStringIndex("one two", 1)), "[[0 3]]")
+	Assert(t, fmt.Sprint(r.FindAllStringSubmatch("1-2 3- 4", -1)), "[[1-2 1 2] [3- 3 ]]")
+
+	//the search only goes as far as it needs to
+	calls := 0
+	counted := &Regex{exprTree: func(input string, pos int, captures []int, k continuation) RegResult {
+		calls++
+		return words.exprTree(input, pos, captures, k)
+	}, subexpNames: []string{""}}
+	Assert(t, fmt.Sprint(counted.FindAllString("a b c d e f g h", 3)), "[a b c]")
+	Assert(t, calls, 5)
+}
+
+func TestReplace(t *testing.T) {
+	r := MustCompile("([a-z]+)=(?P<value>[a-z0-9]*)")
+	Assert(t, r.ReplaceAllString("a=1, b=2", "$2=$1"), "1=a, 2=b")
+	Assert(t, r.ReplaceAllString("a=1, b=2", "${value}:${1}x"), "1:ax, 2:bx")
+	Assert(t, r.ReplaceAllString("a=1", "[$1x]"), "[]")
+	Assert(t, r.ReplaceAllString("a=1", "$value$$"), "1$")
+	Assert(t, r.ReplaceAllString("a=1", "$9 $missing ${} ${1"), "  ${} ${1")
+	Assert(t, r.ReplaceAllString("a=1", "$"), "$")
+	Assert(t, r.ReplaceAllString("no pairs", "x"), "no pairs")
+	Assert(t, r.ReplaceAllLiteralString("a=1, b=2", "$1"), "$1, $1")
+	Assert(t, r.ReplaceAllStringFunc("a=1, b=2", strings.ToUpper), "A=1, B=2")
+	Assert(t, MustCompile("(a)|b").ReplaceAllString("ab", "<$1>"), "<a><>")
+
+	match := r.FindStringSubmatchIndex("key=val")
+	Assert(t, string(r.ExpandString([]byte("> "), "$value from $1", "key=val", match)), "> val from key")
+	Assert(t, string(r.Expand(nil, []byte("${value}s"), []byte("key=val"), match)), "vals")
+}
+
+func TestSplit(t *testing.T) {
+	comma := MustCompile(",\\s*")
+	Assert(t, fmt.Sprint(comma.Split("a, b,c,  d", -1)), "[a b c d]")
+	Assert(t, fmt.Sprint(comma.Split("a, b,c,  d", 2)), "[a b,c,  d]")
+	Assert(t, fmt.Sprint(comma.Split("a, b,c,  d", 1)), "[a, b,c,  d]")
+	Assert(t, comma.Split("a, b", 0) == nil, true)
+	Assert(t, fmt.Sprintf("%q", comma.Split(",a,", -1)), `["" "a" ""]`)
+	Assert(t, fmt.Sprintf("%q", comma.Split("", -1)), `[""]`)
+	Assert(t, fmt.Sprint(comma.Split("abc", -1)), "[abc]")
+	Assert(t, fmt.Sprint(MustCompile("z+").Split("pizza", -1)), "[pi a]")
+	Assert(t, fmt.Sprint(MustCompile("").Split("abc", -1)), "[a b c]")
+	Assert(t, len(MustCompile("").Split("", -1)), 0)
+	Assert(t, fmt.Sprint(MustCompile("\\s+").Split("  two  words ", -1)), "[ two words ]")
+}
+
+func TestEmptyMatches(t *testing.T) {
+	Assert(t, fmt.Sprint(MustCompile("a*").FindAllStringIndex("baaac", -1)), "[[0 0] [1 4] [5 5]]")
+	Assert(t, fmt.Sprint(MustCompile("x?").FindAllStringIndex("xax", -1)), "[[0 1] [2 3]]")
+	Assert(t, fmt.Sprint(MustCompile("(|b)").FindAllStringIndex("abc", -1)), "[[0 0] [1 1] [2 2] [3 3]]")
+	Assert(t, fmt.Sprint(MustCompile("(b|)").FindAllStringIndex("abc", -1)), "[[0 0] [1 2] [3 3]]")
+	Assert(t, fmt.Sprint(MustCompile("a*").FindAllStringIndex("", -1)), "[[0 0]]")
+	Assert(t, fmt.Sprint(MustCompile("x*").FindAllStringIndex("é", -1)), "[[0 0] [2 2]]")
+	Assert(t, fmt.Sprint(MustCompile("a*").FindAllStringIndex("baaac", 2)), "[[0 0] [1 4]]")
+	_, indices := MustCompile("a*").MatchAll("ab")
+	Assert(t, fmt.Sprint(indices), "[0 2]")
+	Assert(t, MustCompile("a*").ReplaceAllString("baaac", "X"), "XbXcX")
+	Assert(t, MustCompile("x*").ReplaceAllString("abc", "-"), "-a-b-c-")
+	Assert(t, fmt.Sprintf("%q", MustCompile("a*").Split("abaabaccadaaae", 5)), `["" "b" "b" "c" "cadaaae"]`)
+}
+
+func TestUTF8(t *testing.T) {
+	Assert(t, MustCompile("^.$").Matches("é"), true)
+	Assert(t, MustCompile("^..$").Matches("é"), false)
+	Assert(t, MustCompile("^.$").Matches("世"), true)
+	Assert(t, MustCompile("^[é-ü]+$").Matches("éöü"), true)
+	Assert(t, MustCompile("^[é-ü]$").Matches("e"), false)
+	Assert(t, MustCompile("^[^a]$").Matches("é"), true)
+	Assert(t, MustCompile("^\\S$").Matches("é"), true)
+	Assert(t, MustCompile("^é?x$").Matches("x"), true)
+	Assert(t, MustCompile("é+").FindString("aéééb"), "ééé")
+	Assert(t, fmt.Sprint(MustCompile("[^é]").FindStringIndex("éa")), "[2 3]")
+	Assert(t, MustCompile("[^é]").FindString("é"), "")
+	Assert(t, fmt.Sprint(MustCompile(".").FindAllString("añb", -1)), "[a ñ b]")
+	Assert(t, MustCompile("(?<=^.)x").FindString("éx"), "x")
+
+	//each byte of invalid UTF-8 in the input is a character of its own, which is U+FFFD to a set or a literal
+	Assert(t, fmt.Sprint(MustCompile(".").FindAllStringIndex("a\xffb", -1)), "[[0 1] [1 2] [2 3]]")
+	Assert(t, MustCompile("^[\uFFFD]$").Matches("\xff"), true)
+	Assert(t, MustCompile("^\\x{FFFD}$").Matches("\xff"), true)
+	Assert(t, MustCompile("^a\uFFFDb$").Matches("a\xffb"), true)
+	Assert(t, MustCompile("^a\uFFFDb$").Matches("a\uFFFDb"), true)
+	Assert(t, MustCompile("^\uFFFD+$").Matches("\xff\uFFFD\xfe"), true)
+	Assert(t, MustCompile("(?i)^\uFFFD$").Matches("\xff"), true)
+	Assert(t, MustCompile("(?<=\uFFFD)a").FindStringIndex("\xffa")[0], 1)
+	//a stray continuation byte is a character of its own to a lookbehind too
+	Assert(t, fmt.Sprint(MustCompile(".a").FindStringIndex("\x80a")), "[0 2]")
+	Assert(t, fmt.Sprint(MustCompile("(?<=.)a").FindStringIndex("\x80a")), "[1 2]")
+	Assert(t, MustCompile("(?<!.)a").FindStringIndex("\x80a") == nil, true)
+	Assert(t, fmt.Sprint(MustCompile("(?<=[^x])a").FindStringIndex("é\xa9a")), "[3 4]")
+	Assert(t, fmt.Sprint(MustCompile("(?<=é.)a").FindStringIndex("é\xa9a")), "[3 4]")
+	Assert(t, MustCompile("(?<=\\xa9)a").FindStringIndex("éa") == nil, true)
+
+	_, err := Compile("a\xff")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidUTF8)
+	Assert(t, err.(*SyntaxError).Offset, 1)
+	_, err = Compile("[ü-é]")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidCharRange)
+	Assert(t, err.(*SyntaxError).Fragment, "ü-é")
+}
+
+func TestUnicodeClasses(t *testing.T) {
+	Assert(t, MustCompile("^\\p{L}+$").Matches("Ñandú"), true)
+	Assert(t, MustCompile("^\\pL+$").Matches("日本語"), true)
+	Assert(t, MustCompile("^\\pL+$").Matches("abc1"), false)
+	Assert(t, MustCompile("^\\p{Lu}").Matches("Émile"), true)
+	Assert(t, MustCompile("^\\p{Lu}").Matches("émile"), false)
+	Assert(t, MustCompile("^\\p{Greek}+$").Matches("αβγ"), true)
+	Assert(t, MustCompile("^\\p{Greek}+$").Matches("abc"), false)
+	Assert(t, MustCompile("^\\P{Greek}+$").Matches("abc"), true)
+	Assert(t, MustCompile("^\\p{^Greek}+$").Matches("abc"), true)
+	Assert(t, MustCompile("^\\P{^Greek}+$").Matches("αβγ"), true)
+	Assert(t, MustCompile("^\\p{Any}$").Matches("\U0001F600"), true)
+	Assert(t, MustCompile("\\p{Han}+").FindString("the word 漢字 is"), "漢字")
+
+	handle := MustCompile("^[\\p{L}\\d_]+$")
+	Assert(t, handle.Matches("josé_42"), true)
+	Assert(t, handle.Matches("Σωκράτης"), true)
+	Assert(t, handle.Matches("a-b"), false)
+	Assert(t, MustCompile("^[^\\p{L}]+$").Matches("123 !"), true)
+	Assert(t, MustCompile("^[^\\p{L}]+$").Matches("12a"), false)
+	Assert(t, MustCompile("^[\\P{L}x]+$").Matches("1x2"), true)
+	Assert(t, MustCompile("^[a\\\\p]+$").Matches("a\\p"), true)
+}
+
+func TestPerlClasses(t *testing.T) {
+	Assert(t, MustCompile("^\\w+$").Matches("user_42"), true)
+	Assert(t, MustCompile("\\w").Matches("["), false)
+	Assert(t, MustCompile("\\w").Matches("^"), false)
+	Assert(t, MustCompile("\\w").Matches("`"), false)
+	Assert(t, MustCompile("\\w").Matches("é"), false)
+	Assert(t, MustCompile("^\\W$").Matches("é"), true)
+	Assert(t, MustCompile("^[\\w-]+$").Matches("a-b_9"), true)
+	Assert(t, MustCompile("^\\s+$").Matches(" \t\n\r\f\v"), true)
+	Assert(t, MustCompile("\\s").Matches("\u00a0"), false)
+	Assert(t, MustCompile("\\d").Matches("٣"), false)
+	Assert(t, MustCompile("\\bcafé\\b").FindString("un café noir"), "") //é isn't a word character, so there is no boundary after it
+
+	unicode := func(regex string) *Regex {
+		return MustCompile(regex, UnicodeClasses)
+	}
+	Assert(t, unicode("^\\w+$").Matches("josé_42"), true)
+	Assert(t, unicode("^\\w+$").Matches("Σωκράτης"), true)
+	Assert(t, unicode("^\\w+$").Matches("a-b"), false)
+	Assert(t, unicode("^\\W$").Matches("é"), false)
+	Assert(t, unicode("^\\d+$").Matches("٣4"), true)
+	Assert(t, unicode("^\\D$").Matches("٣"), false)
+	Assert(t, unicode("^\\s$").Matches("\u00a0"), true)
+	Assert(t, unicode("^[\\w.]+$").Matches("naïve.txt"), true)
+	Assert(t, unicode("\\bcafé\\b").FindString("un café noir"), "café")
+	Assert(t, unicode("\\Bé").FindString("é café"), "é")
+	Assert(t, fmt.Sprint(unicode("\\Bé").FindStringIndex("é café")), "[6 8]")
+}
+
+func TestPosixClasses(t *testing.T) {
+	Assert(t, MustCompile("^[[:alpha:]]+$").Matches("abcXYZ"), true)
+	Assert(t, MustCompile("^[[:alpha:]]+$").Matches("abc1"), false)
+	Assert(t, MustCompile("^[[:digit:]]+$").Matches("0123456789"), true)
+	Assert(t, MustCompile("^[[:alnum:]_]+$").Matches("user_42"), true)
+	Assert(t, MustCompile("^[[:space:]]+$").Matches(" \t\n\v\f\r"), true)
+	Assert(t, MustCompile("^[[:upper:]][[:lower:]]+$").Matches("Hello"), true)
+	Assert(t, MustCompile("^[[:upper:]][[:lower:]]+$").Matches("hello"), false)
+	Assert(t, MustCompile("^[[:xdigit:]]+$").Matches("0xDEADbeef"), false)
+	Assert(t, MustCompile("^0x[[:xdigit:]]+$").Matches("0xDEADbeef"), true)
+	Assert(t, MustCompile("^[[:punct:]]+$").Matches("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"), true)
+	Assert(t, MustCompile("[[:punct:]]").Matches("a"), false)
+	Assert(t, MustCompile("^[[:blank:]]+$").Matches(" \t"), true)
+	Assert(t, MustCompile("^[[:cntrl:]]$").Matches("\x7f"), true)
+	Assert(t, MustCompile("^[[:print:]]+$").Matches("a b~"), true)
+	Assert(t, MustCompile("^[[:graph:]]$").Matches(" "), false)
+	Assert(t, MustCompile("^[[:ascii:]]$").Matches("é"), false)
+
+	Assert(t, MustCompile("^[[:^digit:]]+$").Matches("abc"), true)
+	Assert(t, MustCompile("^[[:^digit:]]+$").Matches("ab1"), false)
+	Assert(t, MustCompile("^[^[:digit:]]+$").Matches("abc"), true)
+	Assert(t, MustCompile("^[^[:digit:][:space:]]+$").Matches("a b"), false)
+	Assert(t, MustCompile("^[[:digit:]a-f]+$").Matches("9fa0"), true)
+	Assert(t, MustCompile("[[:digit:]]+").FindString("abc 123 def"), "123")
+
+	//without the closing :] the [ is just a character in the set
+	Assert(t, MustCompile("^[[:a]+$").Matches(":[a"), true)
+	Assert(t, MustCompile("^[\\[:alpha:]+$").Matches("[:ah"), true)
+	Assert(t, MustCompile("^[\\[:alpha:]]$").Matches("a]"), true) //an escaped [ doesn't start a class either
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	Assert(t, MustCompile("(?i)content-type").Matches("Content-Type"), true)
+	Assert(t, MustCompile("content-type").Matches("Content-Type"), false)
+	Assert(t, MustCompile("content-type", CaseInsensitive).Matches("CONTENT-TYPE"), true)
+	Assert(t, MustCompile("(?i)^[a-c]+$").Matches("aBc"), true)
+	Assert(t, MustCompile("(?i)^[^a-c]$").Matches("B"), false)
+	Assert(t, MustCompile("(?i)^[xyz]$").Matches("Y"), true)
+	Assert(t, MustCompile("(?i)^[[:lower:]]+$").Matches("ABC"), true)
+	Assert(t, MustCompile("(?i)^\\p{Lu}+$").Matches("abc"), true)
+	Assert(t, MustCompile("(?i)^\\.$").Matches("."), true)
+
+	//letters fold with Unicode simple case folding, not just ASCII
+	Assert(t, MustCompile("(?i)straße").Matches("STRAẞE"), true)
+	Assert(t, MustCompile("(?i)σ").Matches("ς"), true)
+	Assert(t, MustCompile("(?i)k").Matches("\u212a"), true)
+	Assert(t, MustCompile("(?i)^é+$").Matches("éÉ"), true)
+
+	//(?i) lasts until the end of the enclosing group, and (?i:...) only covers its contents
+	Assert(t, MustCompile("a(?i)b").Matches("aB"), true)
+	Assert(t, MustCompile("a(?i)b").Matches("AB"), false)
+	Assert(t, MustCompile("(a(?i)b)c").Matches("aBc"), true)
+	Assert(t, MustCompile("(a(?i)b)c").Matches("aBC"), false)
+	Assert(t, MustCompile("(?i:a)b").Matches("Ab"), true)
+	Assert(t, MustCompile("(?i:a)b").Matches("AB"), false)
+	Assert(t, MustCompile("^(?i:ab)+$").Matches("aBAb"), true)
+	Assert(t, MustCompile("(?i:a)(b)").FindStringSubmatch("Ab")[1], "b")
+	Assert(t, MustCompile("(?im:^x)").Matches("y\nX"), false)
+	Assert(t, MustCompile("(?im:^x)").FindString("y\nX"), "X")
+
+	_, err := Compile("(?i:*)")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	_, err = Compile("(?i:a")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingParen)
+}
+
+func TestInlineFlags(t *testing.T) {
+	Assert(t, MustCompile("a.b").Matches("a\nb"), false)
+	Assert(t, MustCompile("a.b").Matches("a b"), true)
+	Assert(t, MustCompile("(?s)a.b").Matches("a\nb"), true)
+	Assert(t, MustCompile("(?s:a.)b.").Matches("a\nb\n"), false)
+	Assert(t, MustCompile("(?s:a.)b.").Matches("a\nbc"), true)
+	Assert(t, MustCompile("a[^x]b").Matches("a\nb"), true)
+
+	//(?U) swaps greedy and lazy quantifiers
+	Assert(t, MustCompile("(?U)a+").FindString("aaa"), "a")
+	Assert(t, MustCompile("(?U)a+?").FindString("aaa"), "aaa")
+	Assert(t, MustCompile("(?U)a{1,3}").FindString("aaa"), "a")
+	Assert(t, MustCompile("(?U)<(.*)>").FindStringSubmatch("<a><b>")[1], "a")
+	Assert(t, MustCompile("(?U)a*+a").Matches("aaa"), false)
+	Assert(t, MustCompile("(?U:a+)a+").FindString("aaa"), "aaa")
+
+	//a - turns the flags after it off
+	Assert(t, MustCompile("(?i)a(?-i)b").Matches("Ab"), true)
+	Assert(t, MustCompile("(?i)a(?-i)b").Matches("AB"), false)
+	Assert(t, MustCompile("(?i)a(?-i:b)c").Matches("AbC"), true)
+	Assert(t, MustCompile("(?i)a(?-i:b)c").Matches("ABC"), false)
+	Assert(t, MustCompile("(?is-m:a.$)").Matches("A\n"), true)
+	Assert(t, MustCompile("(?m)(?-m:a$)").FindString("a\nb"), "")
+	Assert(t, MustCompile("(?ms-i)^a.b$").FindString("x\na\nb"), "a\nb")
+	Assert(t, MustCompile("a(?s-s).").Matches("a\n"), false)
+
+	//flags set inside a group end with it
+	Assert(t, MustCompile("((?s)a.)b.").Matches("a\nb\n"), false)
+	Assert(t, MustCompile("(?:(?i)a)a").Matches("Aa"), true)
+	Assert(t, MustCompile("(?:(?i)a)a").Matches("AA"), false)
+	Assert(t, MustCompile("(?i:(?-i)a)").Matches("A"), false)
+}
+
+func TestExtended(t *testing.T) {
+	date := MustCompile(`(?x)
+		^(?P<year>\d{4})  # the year
+		- (?P<month>\d{2}) # the month
+		- (?P<day>\d{2})   # the day
+		$`)
+	res := date.Match("2024-03-17")
+	Assert(t, res.Success, true)
+	Assert(t, res.Named()["month"], "03")
+	Assert(t, date.Matches("2024 - 03 - 17"), false)
+
+	Assert(t, MustCompile("(?x) a b c ").FindString("xabc"), "abc")
+	Assert(t, MustCompile("(?x) ab c *").FindString("abccc"), "abccc")
+	Assert(t, MustCompile("(?x) a b + ").FindString("abb"), "abb")
+	Assert(t, MustCompile("(?x)ab +").FindString("abbb"), "abbb")
+	Assert(t, MustCompile("(?x)ab +").FindString("abab"), "ab")
+	Assert(t, MustCompile("(?x)ab #c\n+").FindString("abbb"), "abbb")
+	Assert(t, MustCompile("(?x)\\Qab\\E +").FindString("abbb"), "abbb")
+	Assert(t, MustCompile("(?x)ab cd+").FindString("abcdd"), "abcdd")
+	Assert(t, MustCompile("(?x) a # b").Matches("a"), true)
+	Assert(t, MustCompile("(?x) a # b").FindString("ab"), "a")
+	Assert(t, MustCompile("(?x)a\\ b\\#c").Matches("a b#c"), true)
+	Assert(t, MustCompile("(?x)^[ #]+$").Matches(" # "), true)
+	Assert(t, MustCompile("(?x:a b) c").Matches("ab c"), true)
+	Assert(t, MustCompile("(?x:a b) c").Matches("abc"), false)
+	Assert(t, MustCompile("a b(?x) c").Matches("a bc"), true)
+	Assert(t, MustCompile("(?x)a(?-x) b").Matches("a b"), true)
+
+	_, err := Compile("(?x)a # (")
+	Assert(t, err, nil)
+	_, err = Compile("(?x) * # nothing to repeat")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	Assert(t, err.(*SyntaxError).Offset, 5)
+	//a gap straight after a flag group doesn't let a quantifier reach back past it
+	_, err = Compile("a(?x)*")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	_, err = Compile("a(?x) *")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	Assert(t, err.(*SyntaxError).Offset, 6)
+	_, err = Compile("a(?x) # gap\n +")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	Assert(t, MustCompile("(?x)a(?i) b *").FindString("aBBb"), "aBBb")
+}
+
+func TestEscapes(t *testing.T) {
+	Assert(t, MustCompile("a\\nb").Matches("a\nb"), true)
+	Assert(t, MustCompile("a\\nb").Matches("anb"), false)
+	Assert(t, MustCompile("^\\r\\n\\f\\v\\a\\t$").Matches("\r\n\f\v\a\t"), true)
+	Assert(t, MustCompile("^\\x41\\x{42}\\u0043$").Matches("ABC"), true)
+	Assert(t, MustCompile("^\\x{1F600}$").Matches("\U0001F600"), true)
+	Assert(t, MustCompile("^\\u00e9$").Matches("é"), true)
+	Assert(t, MustCompile("^\\x00\\xff$").Matches("\x00ÿ"), true)
+	Assert(t, MustCompile("^\\0\\07\\101$").Matches("\x00\aA"), true)
+	Assert(t, MustCompile("^\\1011$").Matches("A1"), true)
+	Assert(t, MustCompile("^\\x41+$").Matches("AAA"), true)
+	Assert(t, MustCompile("^\\x2e$").Matches("x"), false)
+	Assert(t, MustCompile("(?i)^\\x41$").Matches("a"), true)
+	Assert(t, MustCompile("^\\.\\*\\_\\ \\\"$").Matches(".*_ \""), true)
+
+	//inside sets
+	Assert(t, MustCompile("^[\\n\\t]+$").Matches("\n\t\n"), true)
+	Assert(t, MustCompile("^[\\x00-\\x1f]+$").Matches("\x01\x1f"), true)
+	Assert(t, MustCompile("^[\\x00-\\x1f]+$").Matches(" "), false)
+	Assert(t, MustCompile("^[\\u0391-\\u03a9]+$").Matches("ΑΒΓ"), true)
+	Assert(t, MustCompile("^[^\\x{0}-\\x{7f}]$").Matches("é"), true)
+	Assert(t, MustCompile("^[\\\\-a]+$").Matches("\\_a"), true)
+	Assert(t, MustCompile("^[a\\-z]+$").Matches("-az"), true)
+	Assert(t, MustCompile("^[a\\-z]+$").Matches("b"), false)
+	Assert(t, MustCompile("^[\\b]$").Matches("\b"), true)
+	Assert(t, MustCompile("^[\\b]$").Matches("b"), false)
+	Assert(t, MustCompile("^[\\101-\\x43]+$").Matches("ABC"), true)
+}
+
+func TestQuoteMeta(t *testing.T) {
+	Assert(t, QuoteMeta("1.5+2=3.5?"), "1\\.5\\+2=3\\.5\\?")
+	Assert(t, QuoteMeta("plain"), "plain")
+	Assert(t, QuoteMeta("héllo"), "héllo")
+	Assert(t, QuoteMeta(`\.+*?()|[]{}^$`), `\\\.\+\*\?\(\)\|\[\]\{\}\^\$`)
+	for _, s := range []string{"a.b", "(x|y)*", "[a-z]{2}", "C:\\dir\\x41", "$1 # not a comment", "tab\there\nnewline", "ünï.cödé"} {
+		Assert(t, MustCompile("^"+QuoteMeta(s)+"$").Matches(s), true)
+		Assert(t, MustCompile("(?x)^"+QuoteMeta(s)+"$").Matches(s), true)
+	}
+	Assert(t, MustCompile(QuoteMeta("a.b")).Matches("axb"), false)
+}
+
+func TestQuotedSpan(t *testing.T) {
+	Assert(t, MustCompile("^\\Qa.b*\\E$").Matches("a.b*"), true)
+	Assert(t, MustCompile("^\\Qa.b*\\E$").Matches("aab"), false)
+	Assert(t, MustCompile("^x\\Q(y)\\Ez$").Matches("x(y)z"), true)
+	Assert(t, MustCompile("^\\Q[a-z]").Matches("[a-z]"), true)
+	Assert(t, MustCompile("^\\Q\\d\\\\E$").Matches("\\d\\"), true)
+	Assert(t, MustCompile("^\\Qab\\E+$").Matches("abbb"), true)
+	Assert(t, MustCompile("^\\Qab\\E+$").Matches("abab"), false)
+	Assert(t, MustCompile("^(?:\\Qab\\E)+$").Matches("abab"), true)
+	Assert(t, MustCompile("^\\Qé\\E{2}$").Matches("éé"), true)
+	Assert(t, MustCompile("^\\Qab\\Ecd$").Matches("abcd"), true)
+	Assert(t, MustCompile("^\\Q\\Ea$").Matches("a"), true)
+	Assert(t, MustCompile("(?i)^\\QA.B\\E$").Matches("a.b"), true)
+	Assert(t, MustCompile("(?x)^\\Qa b # c\\E$").Matches("a b # c"), true)
+
+	_, err := Compile("\\Q\\E*")
+	Assert(t, err.(*SyntaxError).Kind, ErrMissingRepeatArgument)
+	_, err = Compile("a\\Qb\\E**")
+	Assert(t, err.(*SyntaxError).Kind, ErrInvalidRepeatOp)
+	Assert(t, err.(*SyntaxError).Offset, 6)
+}
+
 func Assert(t *testing.T, value, expected interface{}) {
 	if value != expected {
 		t.Error(fmt.Sprint("expected ", expected, " but got ", value))
@@ -0,0 +1,109 @@
+package regox
+
+import (
+	"strconv"
+	"strings"
+)
+
+//ReplaceAllString returns a copy of src with every match of the regex replaced by repl.  $ signs in repl are expanded as in Expand, so $1 stands for the first capture group
+func (regex *Regex) ReplaceAllString(src, repl string) string {
+	return regex.replaceAll(src, func(dst []byte, match []int) []byte {
+		return regex.expand(dst, repl, src, match)
+	})
+}
+
+//ReplaceAllLiteralString returns a copy of src with every match of the regex replaced by repl, which is used as it is without expanding $ signs
+func (regex *Regex) ReplaceAllLiteralString(src, repl string) string {
+	return regex.replaceAll(src, func(dst []byte, match []int) []byte {
+		return append(dst, repl...)
+	})
+}
+
+//ReplaceAllStringFunc returns a copy of src with every match of the regex replaced by what repl returns for it.  The result of repl is not expanded
+func (regex *Regex) ReplaceAllStringFunc(src string, repl func(string) string) string {
+	return regex.replaceAll(src, func(dst []byte, match []int) []byte {
+		return append(dst, repl(src[match[0]:match[1]])...)
+	})
+}
+
+//Expand appends template to dst with the capture groups of a match substituted in, and returns the result.  match holds the offsets of the
+//match into src, as returned by FindSubmatchIndex.  In the template, $n or ${n} stands for capture group n, $name or ${name} for the group
+//with that name, and $$ for a literal $.  $name takes the longest run of letters, digits and underscores it can, so use ${1}x rather than $1x.
+//References to groups that don't exist or didn't take part in the match are replaced with nothing
+func (regex *Regex) Expand(dst []byte, template []byte, src []byte, match []int) []byte {
+	return regex.expand(dst, string(template), string(src), match)
+}
+
+//ExpandString is like Expand but takes the template and source as strings
+func (regex *Regex) ExpandString(dst []byte, template string, src string, match []int) []byte {
+	return regex.expand(dst, template, src, match)
+}
+
+//replaceAll builds a copy of src, letting replace append the replacement for each match found by allMatches in place of the match
+func (regex *Regex) replaceAll(src string, replace func(dst []byte, match []int) []byte) string {
+	dst := make([]byte, 0, len(src))
+	last := 0
+	regex.allMatches(src, -1, func(res RegResult) {
+		dst = append(dst, src[last:res.groups[0]]...)
+		dst = replace(dst, res.groups)
+		last = res.groups[1]
+	})
+	dst = append(dst, src[last:len(src)]...)
+	return string(dst)
+}
+
+func (regex *Regex) expand(dst []byte, template string, src string, match []int) []byte {
+	for {
+		i := strings.IndexByte(template, '$')
+		if i == -1 {
+			break
+		}
+		dst = append(dst, template[0:i]...)
+		template = template[i:len(template)]
+		if strings.HasPrefix(template, "$$") {
+			dst = append(dst, '$')
+			template = template[2:len(template)]
+			continue
+		}
+		name, rest := templateName(template)
+		if name == "" {
+			//not a reference, so the $ is just text
+			dst = append(dst, '$')
+			template = template[1:len(template)]
+			continue
+		}
+		template = rest
+		group := -1
+		if num, err := strconv.Atoi(name); err == nil {
+			group = num
+		} else {
+			group = regex.SubexpIndex(name)
+		}
+		if group >= 0 && 2*group+1 < len(match) && match[2*group] >= 0 {
+			dst = append(dst, src[match[2*group]:match[2*group+1]]...)
+		}
+	}
+	return append(dst, template...)
+}
+
+//templateName reads the group reference at the start of template, which begins with a $.  It returns the referenced name and the rest of the template,
+//or an empty name if there is no well formed reference
+func templateName(template string) (string, string) {
+	braced := strings.HasPrefix(template, "${")
+	start := 1
+	if braced {
+		start = 2
+	}
+	end := start
+	for end < len(template) && isWordChar(rune(template[end])) {
+		end++
+	}
+	name := template[start:end]
+	if braced {
+		if end == len(template) || template[end] != '}' {
+			return "", template
+		}
+		end++
+	}
+	return name, template[end:len(template)]
+}
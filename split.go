@@ -0,0 +1,30 @@
+package regox
+
+//Split slices s into the substrings between matches of the regex and returns them.  n limits the number of substrings returned:
+//n > 0 returns at most n substrings, the last being the unsplit remainder; n == 0 returns nil; and n < 0 returns all of them.
+//An empty match at the very start of s doesn't produce an empty first substring, and splitting "" with a non-empty regex gives [""]
+func (regex *Regex) Split(s string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	if len(regex.expression) > 0 && len(s) == 0 {
+		return []string{""}
+	}
+	pieces := make([]string, 0)
+	begin := 0
+	end := 0
+	regex.allMatches(s, n, func(res RegResult) {
+		if n > 0 && len(pieces) == n-1 {
+			return
+		}
+		end = res.groups[0]
+		if res.groups[1] != 0 {
+			pieces = append(pieces, s[begin:end])
+		}
+		begin = res.groups[1]
+	})
+	if end != len(s) {
+		pieces = append(pieces, s[begin:len(s)])
+	}
+	return pieces
+}